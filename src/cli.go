@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/njannasch/ai-context-firewall/firewall"
+)
+
+// inspectTimeout bounds the inspect command.
+const inspectTimeout = 60 * time.Second
+
+// defaultConfigPath is config.json next to the executable.
+func defaultConfigPath() string {
+	if exe, err := os.Executable(); err == nil {
+		return filepath.Join(filepath.Dir(exe), "config.json")
+	}
+	return "config.json"
+}
+
+// applyEnvOverrides applies the environment variables that override config
+// values and reports whether any were set.
+func applyEnvOverrides(cfg *firewall.Config) bool {
+	changed := false
+	for env, field := range map[string]*string{
+		"BACKEND_URL":     &cfg.BackendURL,
+		"INSPECTOR_URL":   &cfg.InspectorURL,
+		"INSPECTOR_MODEL": &cfg.InspectorModel,
+		"PROXY_ADDR":      &cfg.ProxyAddr,
+		"WEB_ADDR":        &cfg.WebAddr,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// openStore loads the config file for a subcommand, with the environment
+// overrides applied for this run only; the CLI never writes the config file.
+func openStore(configPath string) (*firewall.Store, error) {
+	store, err := firewall.NewStore(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	cfg := store.GetConfig()
+	if applyEnvOverrides(&cfg) {
+		if store, err = firewall.NewMemoryStore(cfg); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// runInspectCommand implements `firewall inspect [flags] [text]`: one
+// inspection with the configured inspector, printed as JSON. The text comes
+// from the arguments or, if there are none, from stdin. The exit code is 0
+// when the content would be forwarded, 1 when it would be blocked, and 2 on
+// errors, so it can gate CI jobs.
+func runInspectCommand(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "Config file path")
+	preset := fs.String("prompt-preset", "", "Prompt to inspect with instead of the active one")
+	compact := fs.Bool("json", false, "Print compact single-line JSON instead of indented output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s inspect [flags] [text]\n\nInspects text (or stdin) and prints the verdict as JSON.\n\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	content := strings.Join(fs.Args(), " ")
+	if content == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read stdin: %v\n", err)
+			return 2
+		}
+		content = string(data)
+	}
+
+	store, err := openStore(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	cfg := store.GetConfig()
+
+	inspector := firewall.NewInspector(store)
+	if *preset != "" && !slices.Contains(inspector.PromptNames(), *preset) {
+		fmt.Fprintf(os.Stderr, "unknown prompt preset %q (available: %s)\n", *preset, strings.Join(inspector.PromptNames(), ", "))
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inspectTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var result *firewall.InspectionResult
+	if *preset != "" {
+		result, err = inspector.InspectWithPrompt(ctx, content, *preset)
+	} else {
+		result, err = inspector.Inspect(ctx, content)
+	}
+	out := firewall.CompareResult{InspectionResult: result, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Blocked = result.Score >= cfg.Threshold
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	if !*compact {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(out)
+
+	switch {
+	case err != nil:
+		return 2
+	case out.Blocked:
+		return 1
+	}
+	return 0
+}
+
+// runVerifyAuditCommand implements `firewall verify-audit <file>`: it checks
+// the hash chain of an audit file written with audit_hash_chain. The exit
+// code is 0 when the chain is intact, 1 when it is broken and 2 on errors.
+func runVerifyAuditCommand(args []string) int {
+	fs := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify-audit <file>\n\nVerifies the hash chain of an audit file.\n", filepath.Base(os.Args[0]))
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	chained, err := firewall.VerifyAuditChain(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v (%d records verified before it)\n", fs.Arg(0), err, chained)
+		return 1
+	}
+	if chained == 0 {
+		fmt.Printf("%s: no chained records\n", fs.Arg(0))
+		return 0
+	}
+	fmt.Printf("%s: chain intact (%d records)\n", fs.Arg(0), chained)
+	return 0
+}
+
+// replayConcurrency is how many log entries the replay command inspects in
+// parallel by default.
+const replayConcurrency = 4
+
+// replayEntry is one replayed log entry.
+type replayEntry struct {
+	ID         int    `json:"id"`
+	Content    string `json:"content"`
+	OldAction  string `json:"old_action"`
+	OldScore   int    `json:"old_score"`
+	NewScore   int    `json:"new_score"`
+	NewBlocked bool   `json:"new_blocked"`
+	// Change is "newly blocked" or "newly allowed" when the decision flipped.
+	Change string `json:"change,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type replaySummary struct {
+	Replayed     int `json:"replayed"`
+	Unchanged    int `json:"unchanged"`
+	NewlyBlocked int `json:"newly_blocked"`
+	NewlyAllowed int `json:"newly_allowed"`
+	Errors       int `json:"errors"`
+	// Skipped counts entries stored without their full content.
+	Skipped int `json:"skipped"`
+}
+
+// runReplayCommand implements `firewall replay [flags] [file]`: it re-inspects
+// the content of exported log entries with the config and reports which block
+// decisions would change. The entries come as JSON Lines (the audit file) or a
+// JSON array (GET /api/logs), from the file or stdin. The exit code is 0 when
+// no decision changed, 1 when some did, and 2 on errors.
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "Config file path to replay with")
+	preset := fs.String("prompt-preset", "", "Prompt to inspect with instead of the active one")
+	concurrency := fs.Int("concurrency", replayConcurrency, "Entries inspected in parallel")
+	asJSON := fs.Bool("json", false, "Print every replayed entry and the summary as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [flags] [file]\n\nRe-inspects logged content (JSON Lines or a JSON array, from file or stdin)\nand reports which block decisions would change.\n\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 || *concurrency < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		in = f
+	}
+	logs, err := readLogs(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read logs: %v\n", err)
+		return 2
+	}
+
+	store, err := openStore(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	cfg := store.GetConfig()
+	inspector := firewall.NewInspector(store)
+	if *preset != "" && !slices.Contains(inspector.PromptNames(), *preset) {
+		fmt.Fprintf(os.Stderr, "unknown prompt preset %q (available: %s)\n", *preset, strings.Join(inspector.PromptNames(), ", "))
+		return 2
+	}
+
+	var summary replaySummary
+	var replayable []firewall.InspectionLog
+	for _, l := range logs {
+		if fullContent(l.Content) {
+			replayable = append(replayable, l)
+		} else {
+			summary.Skipped++
+		}
+	}
+
+	entries := make([]replayEntry, len(replayable))
+	sem := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	for i, l := range replayable {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, l firewall.InspectionLog) {
+			defer func() { <-sem; wg.Done() }()
+			entries[i] = replay(inspector, cfg, *preset, l)
+		}(i, l)
+	}
+	wg.Wait()
+
+	for _, e := range entries {
+		summary.Replayed++
+		switch {
+		case e.Error != "":
+			summary.Errors++
+		case e.Change == "newly blocked":
+			summary.NewlyBlocked++
+		case e.Change == "newly allowed":
+			summary.NewlyAllowed++
+		default:
+			summary.Unchanged++
+		}
+	}
+
+	if *asJSON {
+		printJSON(struct {
+			Summary replaySummary `json:"summary"`
+			Entries []replayEntry `json:"entries"`
+		}{summary, entries})
+	} else {
+		for _, e := range entries {
+			switch {
+			case e.Error != "":
+				fmt.Printf("error          #%d  %s\n", e.ID, e.Error)
+			case e.Change != "":
+				fmt.Printf("%-14s #%d  score %d -> %d  %s\n", e.Change, e.ID, e.OldScore, e.NewScore, snippet(e.Content, 80))
+			}
+		}
+		fmt.Printf("replayed %d entries: %d newly blocked, %d newly allowed, %d unchanged, %d errors\n",
+			summary.Replayed, summary.NewlyBlocked, summary.NewlyAllowed, summary.Unchanged, summary.Errors)
+	}
+	if summary.Skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d entries stored without their full content; set log_content_chars to 0 to keep it\n", summary.Skipped)
+	}
+
+	switch {
+	case summary.Errors > 0:
+		return 2
+	case summary.NewlyBlocked+summary.NewlyAllowed > 0:
+		return 1
+	}
+	return 0
+}
+
+// replay re-inspects one log entry and compares the block decisions.
+func replay(inspector *firewall.Inspector, cfg firewall.Config, preset string, l firewall.InspectionLog) replayEntry {
+	e := replayEntry{ID: l.ID, Content: l.Content, OldAction: l.Action, OldScore: l.Score}
+	ctx, cancel := context.WithTimeout(context.Background(), inspectTimeout)
+	defer cancel()
+
+	var result *firewall.InspectionResult
+	var err error
+	if preset != "" {
+		result, err = inspector.InspectWithPrompt(ctx, l.Content, preset)
+	} else {
+		result, err = inspector.Inspect(ctx, l.Content)
+	}
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.NewScore = result.Score
+	e.NewBlocked = result.Score >= cfg.Threshold
+	wasBlocked := strings.HasPrefix(l.Action, "blocked")
+	switch {
+	case e.NewBlocked && !wasBlocked:
+		e.Change = "newly blocked"
+	case !e.NewBlocked && wasBlocked:
+		e.Change = "newly allowed"
+	}
+	return e
+}
+
+// readLogs decodes log entries from JSON Lines or a JSON array.
+func readLogs(r io.Reader) ([]firewall.InspectionLog, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	var logs []firewall.InspectionLog
+	if first, err := firstByte(br); err == nil && first == '[' {
+		err := dec.Decode(&logs)
+		return logs, err
+	}
+	for {
+		var l firewall.InspectionLog
+		if err := dec.Decode(&l); err == io.EOF {
+			return logs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", len(logs)+1, err)
+		}
+		logs = append(logs, l)
+	}
+}
+
+// firstByte peeks at the first non-space byte of br.
+func firstByte(br *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		if c := b[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+// fullContent reports whether logged content can be replayed: not empty,
+// hashed by hash-only logging, or cut off at log_content_chars.
+func fullContent(content string) bool {
+	return content != "" && !strings.HasPrefix(content, "sha256:") && !strings.HasSuffix(content, "...")
+}
+
+// snippet shortens content to one line of at most n characters for display.
+func snippet(content string, n int) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if r := []rune(content); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return content
+}
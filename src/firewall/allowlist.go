@@ -0,0 +1,186 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errLogNotFound = errors.New("log entry not found")
+
+// LearnedAllowEntry is content that was marked as a false positive. Requests
+// whose signature matches are forwarded without inspection.
+type LearnedAllowEntry struct {
+	Signature string     `json:"signature"`
+	Snippet   string     `json:"snippet,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	LogID     int        `json:"log_id,omitempty"`
+	AddedAt   time.Time  `json:"added_at"`
+	Hits      int        `json:"hits"`
+	LastHit   *time.Time `json:"last_hit,omitempty"`
+}
+
+// learnedAllowlistPath puts the learned allowlist next to the config file,
+// e.g. config.json -> config.allowlist.json.
+func learnedAllowlistPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".allowlist.json"
+}
+
+// contentSignature hashes content after normalization, case folding and
+// whitespace collapsing, so trivially reformatted copies of a reviewed
+// request still match while any change in wording does not.
+func contentSignature(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(normalizeForInspection(content, true))), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// validSignature accepts hex SHA-256 digests as produced by contentSignature.
+func validSignature(sig string) bool {
+	_, err := hex.DecodeString(sig)
+	return err == nil && len(sig) == 2*sha256.Size
+}
+
+func loadLearnedAllowlist(configPath string) map[string]*LearnedAllowEntry {
+	learned := map[string]*LearnedAllowEntry{}
+	data, err := os.ReadFile(learnedAllowlistPath(configPath))
+	if err != nil {
+		return learned
+	}
+	var entries []LearnedAllowEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("ignoring learned allowlist: %v", err)
+		return learned
+	}
+	for i := range entries {
+		if !validSignature(entries[i].Signature) {
+			log.Printf("ignoring learned allowlist entry with invalid signature %q", entries[i].Signature)
+			continue
+		}
+		learned[entries[i].Signature] = &entries[i]
+	}
+	return learned
+}
+
+// LearnedAllowlist returns the learned entries, newest first.
+func (s *Store) LearnedAllowlist() []LearnedAllowEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.learnedList()
+}
+
+// learnedList is LearnedAllowlist for callers holding s.mu.
+func (s *Store) learnedList() []LearnedAllowEntry {
+	result := make([]LearnedAllowEntry, 0, len(s.learned))
+	for _, e := range s.learned {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AddedAt.After(result[j].AddedAt) })
+	return result
+}
+
+// AddLearned adds an entry, or updates the note of an existing one, and
+// persists the allowlist.
+func (s *Store) AddLearned(e LearnedAllowEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.learned[e.Signature]; ok {
+		if e.Note != "" {
+			existing.Note = e.Note
+		}
+		return s.saveLearned()
+	}
+	e.AddedAt = time.Now()
+	s.learned[e.Signature] = &e
+	return s.saveLearned()
+}
+
+// RemoveLearned deletes an entry and reports whether it existed.
+func (s *Store) RemoveLearned(signature string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.learned[signature]; !ok {
+		return false, nil
+	}
+	delete(s.learned, signature)
+	return true, s.saveLearned()
+}
+
+// matchLearned looks up a content signature in the learned allowlist and
+// counts the hit. Hit counts are kept in memory and written with the next
+// allowlist change.
+func (s *Store) matchLearned(signature string) (LearnedAllowEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.learned) == 0 {
+		return LearnedAllowEntry{}, false
+	}
+	e, ok := s.learned[signature]
+	if !ok {
+		return LearnedAllowEntry{}, false
+	}
+	now := time.Now()
+	e.Hits++
+	e.LastHit = &now
+	return *e, true
+}
+
+// saveLearned writes the allowlist file. The caller holds s.mu.
+func (s *Store) saveLearned() error {
+	if s.configPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.learnedList(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(learnedAllowlistPath(s.configPath), data, 0644)
+}
+
+// Flagged reports whether the firewall acted on an inspected entry, i.e. it
+// was blocked or warned, so it can be reviewed as a false positive. Blocked
+// responses don't count: allowlisting the request wouldn't change them.
+func (l InspectionLog) Flagged() bool {
+	return l.Signature != "" && l.detected()
+}
+
+// MarkFalsePositive flags a log entry as a false positive. With learn set,
+// its content signature is also added to the learned allowlist.
+func (s *Store) MarkFalsePositive(id int, learn bool, note string) (InspectionLog, error) {
+	s.mu.Lock()
+	var entry *InspectionLog
+	for i := range s.logs {
+		if s.logs[i].ID == id {
+			entry = &s.logs[i]
+			break
+		}
+	}
+	if entry == nil {
+		s.mu.Unlock()
+		return InspectionLog{}, errLogNotFound
+	}
+	entry.FalsePositive = true
+	entry.ReviewLabel = reviewBenign
+	marked := *entry
+	s.mu.Unlock()
+
+	if !learn {
+		return marked, nil
+	}
+	if marked.Signature == "" {
+		return marked, errors.New("log entry has no content signature to learn")
+	}
+	return marked, s.AddLearned(LearnedAllowEntry{
+		Signature: marked.Signature,
+		Snippet:   truncate(marked.Content, 120),
+		Note:      note,
+		LogID:     marked.ID,
+	})
+}
@@ -0,0 +1,155 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// anthropicMessagesPath is Anthropic's Messages API, proxied for gateways
+// and clients that speak it instead of Ollama's API.
+const anthropicMessagesPath = "/v1/messages"
+
+// anthropicBlock is a content block. Tool results carry their own content,
+// a string or further blocks.
+type anthropicBlock struct {
+	Type    string          `json:"type"`
+	Text    string          `json:"text"`
+	Content json.RawMessage `json:"content"`
+}
+
+// anthropicContent reads a content field, either a plain string or a list of
+// blocks, returning the text of the blocks and of any tool results
+// separately.
+func anthropicContent(raw json.RawMessage) (text, toolText []string, hasImages bool) {
+	if len(raw) == 0 {
+		return nil, nil, false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if s == "" {
+			return nil, nil, false
+		}
+		return []string{s}, nil, false
+	}
+	var blocks []anthropicBlock
+	if json.Unmarshal(raw, &blocks) != nil {
+		return nil, nil, false
+	}
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			if b.Text != "" {
+				text = append(text, b.Text)
+			}
+		case "image", "document":
+			hasImages = true
+		case "tool_result":
+			inner, nested, img := anthropicContent(b.Content)
+			toolText = append(toolText, inner...)
+			toolText = append(toolText, nested...)
+			hasImages = hasImages || img
+		}
+	}
+	return text, toolText, hasImages
+}
+
+func (p *Proxy) handleMessages(w http.ResponseWriter, r *http.Request) {
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Model    string          `json:"model"`
+		Stream   bool            `json:"stream"`
+		System   json.RawMessage `json:"system"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON")
+		return
+	}
+
+	// The system prompt is a top-level field and tool results arrive as
+	// blocks in user messages; both are filtered like their Ollama roles.
+	cfg := p.store.GetConfig()
+	var parts []string
+	fromTool := false
+	hasImages := false
+	if cfg.InspectsRole("system") {
+		system, _, _ := anthropicContent(req.System)
+		parts = append(parts, system...)
+	}
+	for _, msg := range req.Messages {
+		text, toolText, img := anthropicContent(msg.Content)
+		hasImages = hasImages || img
+		if cfg.InspectsRole(msg.Role) {
+			parts = append(parts, text...)
+		}
+		if len(toolText) > 0 && cfg.InspectsRole("tool") {
+			parts = append(parts, toolText...)
+			fromTool = true
+		}
+	}
+	content := joinInspected(cfg, parts)
+
+	// Anthropic streams default to off, unlike Ollama's
+	p.inspectAndForward(w, r, body, content, "", req.Model, fromTool, req.Stream, hasImages, false)
+}
+
+// writeAnthropicError sends an error in Anthropic's shape.
+func writeAnthropicError(w http.ResponseWriter, code int, errType, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": msg},
+	})
+}
+
+// respondBlockedAnthropic answers a blocked /v1/messages request with an
+// assistant message stopped for "refusal", as a single JSON message or as the
+// usual sequence of server-sent events, with the given status.
+func respondBlockedAnthropic(w http.ResponseWriter, r *http.Request, msg, model string, stream bool, status int) {
+	id := "msg_blocked_" + strings.ReplaceAll(requestIDFrom(r.Context()), "-", "")
+	usage := map[string]int{"input_tokens": 0, "output_tokens": 0}
+
+	if !stream {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":            id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         model,
+			"content":       []map[string]string{{"type": "text", "text": msg}},
+			"stop_reason":   "refusal",
+			"stop_sequence": nil,
+			"usage":         usage,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	event := func(name string, data map[string]any) {
+		data["type"] = name
+		b, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+	}
+	event("message_start", map[string]any{"message": map[string]any{
+		"id": id, "type": "message", "role": "assistant", "model": model,
+		"content": []any{}, "stop_reason": nil, "stop_sequence": nil, "usage": usage,
+	}})
+	event("content_block_start", map[string]any{"index": 0, "content_block": map[string]string{"type": "text", "text": ""}})
+	event("content_block_delta", map[string]any{"index": 0, "delta": map[string]string{"type": "text_delta", "text": msg}})
+	event("content_block_stop", map[string]any{"index": 0})
+	event("message_delta", map[string]any{"delta": map[string]any{"stop_reason": "refusal", "stop_sequence": nil}, "usage": map[string]int{"output_tokens": 0}})
+	event("message_stop", map[string]any{})
+}
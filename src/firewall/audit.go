@@ -0,0 +1,134 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxConfigHistory is the number of config audit events kept in memory.
+// The history file itself is append-only and never trimmed.
+const maxConfigHistory = 500
+
+// secretConfigFields are masked in the audit trail.
+var secretConfigFields = map[string]bool{
+	"inspector_api_key": true,
+	"override_token":    true,
+}
+
+type ConfigChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// ConfigAuditEvent records one config change: what changed, through which
+// path (web form, API, environment, reload) and from where.
+type ConfigAuditEvent struct {
+	Timestamp time.Time               `json:"timestamp"`
+	Source    string                  `json:"source"`
+	Actor     string                  `json:"actor,omitempty"`
+	Changes   map[string]ConfigChange `json:"changes"`
+}
+
+// configHistoryPath puts the audit trail next to the config file, e.g.
+// config.json -> config.history.jsonl.
+func configHistoryPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".history.jsonl"
+}
+
+// diffConfig lists the fields that differ between two configs, keyed by
+// their JSON names.
+func diffConfig(old, new Config) map[string]ConfigChange {
+	var oldFields, newFields map[string]json.RawMessage
+	a, _ := json.Marshal(old)
+	b, _ := json.Marshal(new)
+	json.Unmarshal(a, &oldFields)
+	json.Unmarshal(b, &newFields)
+
+	changes := map[string]ConfigChange{}
+	for _, fields := range []map[string]json.RawMessage{oldFields, newFields} {
+		for name := range fields {
+			if _, seen := changes[name]; seen || bytes.Equal(oldFields[name], newFields[name]) {
+				continue
+			}
+			if secretConfigFields[name] {
+				changes[name] = ConfigChange{From: "***", To: "***"}
+				continue
+			}
+			var from, to any
+			json.Unmarshal(oldFields[name], &from)
+			json.Unmarshal(newFields[name], &to)
+			changes[name] = ConfigChange{From: from, To: to}
+		}
+	}
+	return changes
+}
+
+// recordConfigChange appends an audit event for old -> new, if anything
+// changed. The caller holds s.mu.
+func (s *Store) recordConfigChange(old, new Config, source, actor string) {
+	changes := diffConfig(old, new)
+	if len(changes) == 0 {
+		return
+	}
+	ev := ConfigAuditEvent{Timestamp: time.Now(), Source: source, Actor: actor, Changes: changes}
+	s.configHistory = append(s.configHistory, ev)
+	if len(s.configHistory) > maxConfigHistory {
+		s.configHistory = s.configHistory[len(s.configHistory)-maxConfigHistory:]
+	}
+
+	if s.configPath == "" {
+		return
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(configHistoryPath(s.configPath), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("failed to write config history: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// loadConfigHistory reads the most recent events from the history file.
+func loadConfigHistory(configPath string) []ConfigAuditEvent {
+	f, err := os.Open(configHistoryPath(configPath))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var events []ConfigAuditEvent
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	for sc.Scan() {
+		var ev ConfigAuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			continue
+		}
+		events = append(events, ev)
+		if len(events) > maxConfigHistory {
+			events = events[1:]
+		}
+	}
+	return events
+}
+
+// ConfigHistory returns config audit events, newest first.
+func (s *Store) ConfigHistory() []ConfigAuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ConfigAuditEvent, len(s.configHistory))
+	for i, ev := range s.configHistory {
+		out[len(out)-1-i] = ev
+	}
+	return out
+}
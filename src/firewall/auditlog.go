@@ -0,0 +1,252 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditGenesisHash is the prev_hash of the first chained record in a file.
+var auditGenesisHash = strings.Repeat("0", 2*sha256.Size)
+
+// auditRecord is one line of the audit file: the log entry, plus with
+// AuditHashChain the SHA-256 of the previous line, so removing, editing or
+// reordering lines breaks the chain.
+type auditRecord struct {
+	InspectionLog
+	PrevHash string `json:"prev_hash,omitempty"`
+}
+
+// auditFile appends every decision to AuditFile, independent of the in-memory
+// log store: clearing or deleting logs in the UI never touches it, and the app
+// only ever appends. Writes are synced every AuditFsyncSec, or after each line
+// when that is 0.
+type auditFile struct {
+	mu       sync.Mutex
+	path     string
+	fsyncSec int
+	f        *os.File
+	prevHash string
+	dirty    bool
+	stop     chan struct{}
+
+	// queue holds entries waiting for the writer goroutine, which runs
+	// while writing is set. enabled records whether the last queued entry
+	// had an audit file configured.
+	qmu     sync.Mutex
+	queue   []auditItem
+	writing bool
+	enabled bool
+}
+
+type auditItem struct {
+	cfg   Config
+	entry InspectionLog
+}
+
+// enqueue hands entry to a writer goroutine, so the caller, which holds the
+// store lock, never waits on the disk. Entries are written in the order they
+// were enqueued, which keeps the hash chain in log order.
+func (a *auditFile) enqueue(cfg Config, entry InspectionLog) {
+	a.qmu.Lock()
+	defer a.qmu.Unlock()
+	// Once disabled, one more entry still goes through so the writer closes
+	// the file
+	if cfg.AuditFile == "" && !a.enabled {
+		return
+	}
+	a.enabled = cfg.AuditFile != ""
+	a.queue = append(a.queue, auditItem{cfg: cfg, entry: entry})
+	if !a.writing {
+		a.writing = true
+		go a.drain()
+	}
+}
+
+// drain writes queued entries until the queue is empty.
+func (a *auditFile) drain() {
+	for {
+		a.qmu.Lock()
+		items := a.queue
+		a.queue = nil
+		if len(items) == 0 {
+			a.writing = false
+			a.qmu.Unlock()
+			return
+		}
+		a.qmu.Unlock()
+		for _, it := range items {
+			a.append(it.cfg, it.entry)
+		}
+	}
+}
+
+// append writes entry to cfg.AuditFile, (re)opening the file when the
+// configured path or sync interval changed. Failures are logged, never
+// returned: the audit trail must not take the proxy down.
+func (a *auditFile) append(cfg Config, entry InspectionLog) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if cfg.AuditFile != a.path || cfg.AuditFsyncSec != a.fsyncSec {
+		a.closeLocked()
+		if cfg.AuditFile == "" {
+			return
+		}
+		if err := a.openLocked(cfg.AuditFile, cfg.AuditFsyncSec); err != nil {
+			log.Printf("audit file: %v", err)
+			return
+		}
+	}
+	if a.f == nil {
+		return
+	}
+
+	rec := auditRecord{InspectionLog: entry}
+	if cfg.AuditHashChain {
+		rec.PrevHash = a.prevHash
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("audit file: %v", err)
+		return
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		log.Printf("audit file: write %s: %v", a.path, err)
+		return
+	}
+	a.prevHash = lineHash(line)
+	if a.fsyncSec <= 0 {
+		a.f.Sync()
+	} else {
+		a.dirty = true
+	}
+}
+
+func (a *auditFile) openLocked(path string, fsyncSec int) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	last, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	a.prevHash = auditGenesisHash
+	if len(last) > 0 {
+		a.prevHash = lineHash(last)
+	}
+	a.f, a.path, a.fsyncSec = f, path, fsyncSec
+	if fsyncSec > 0 {
+		a.stop = make(chan struct{})
+		go a.syncLoop(f, time.Duration(fsyncSec)*time.Second, a.stop)
+	}
+	return nil
+}
+
+func (a *auditFile) closeLocked() {
+	if a.f == nil {
+		a.path, a.fsyncSec = "", 0
+		return
+	}
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+	a.f.Sync()
+	a.f.Close()
+	a.f, a.path, a.fsyncSec, a.dirty = nil, "", 0, false
+}
+
+func (a *auditFile) syncLoop(f *os.File, interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			a.mu.Lock()
+			if a.dirty && a.f == f {
+				if err := f.Sync(); err != nil {
+					log.Printf("audit file: sync %s: %v", a.path, err)
+				}
+				a.dirty = false
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns the last non-empty line of f without its newline, reading
+// backwards so large audit files aren't scanned in full.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	const chunk = 64 << 10
+	end := info.Size()
+	var tail []byte
+	for end > 0 {
+		start := max(0, end-chunk)
+		buf := make([]byte, end-start)
+		if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		end = start
+	}
+	return bytes.TrimRight(tail, "\n"), nil
+}
+
+// VerifyAuditChain checks the hash chain of an audit file. Lines written
+// without AuditHashChain are skipped but still hashed, so the chain holds
+// across the setting being switched on. It returns the number of chained
+// lines, or the 1-based number of the first line whose prev_hash doesn't
+// match.
+func VerifyAuditChain(r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	prev := auditGenesisHash
+	chained := 0
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec struct {
+			PrevHash string `json:"prev_hash"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return chained, fmt.Errorf("line %d: %v", n, err)
+		}
+		if rec.PrevHash != "" {
+			if rec.PrevHash != prev {
+				return chained, fmt.Errorf("line %d: chain broken, prev_hash does not match the preceding line", n)
+			}
+			chained++
+		}
+		prev = lineHash(line)
+	}
+	return chained, sc.Err()
+}
@@ -0,0 +1,117 @@
+package firewall
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Inspect while the breaker is open, without
+// contacting the inspector.
+var ErrCircuitOpen = errors.New("inspector circuit open")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// BreakerState is the circuit breaker as reported by /api/stats.
+type BreakerState struct {
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+// breaker trips after BreakerFailures consecutive inspector failures within
+// BreakerWindowSec. While open, calls fail fast; after BreakerCooldownSec one
+// probe call is let through, and its outcome closes or reopens the circuit.
+type breaker struct {
+	mu           sync.Mutex
+	state        string
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probeAt      time.Time
+}
+
+func newBreaker() *breaker {
+	return &breaker{state: breakerClosed}
+}
+
+// allow reports whether a call may go to the inspector.
+func (b *breaker) allow(cfg Config) bool {
+	if cfg.BreakerFailures <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cooldown := time.Duration(cfg.BreakerCooldownSec) * time.Second
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probeAt = time.Now()
+		log.Printf("inspector circuit half-open, probing")
+		return true
+	case breakerHalfOpen:
+		// Only one probe at a time; if it never reported back (its client
+		// went away), another is allowed after the cooldown
+		if time.Since(b.probeAt) < cooldown {
+			return false
+		}
+		b.probeAt = time.Now()
+		return true
+	}
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		log.Printf("inspector circuit closed")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+func (b *breaker) failure(cfg Config) {
+	if cfg.BreakerFailures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+		b.openedAt = now
+		log.Printf("inspector circuit re-opened: probe failed")
+		return
+	}
+	window := time.Duration(cfg.BreakerWindowSec) * time.Second
+	if b.failures == 0 || (window > 0 && now.Sub(b.firstFailure) > window) {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.state == breakerClosed && b.failures >= cfg.BreakerFailures {
+		b.state = breakerOpen
+		b.openedAt = now
+		log.Printf("inspector circuit OPEN after %d consecutive failures, skipping inspection for %ds", b.failures, cfg.BreakerCooldownSec)
+	}
+}
+
+func (b *breaker) snapshot() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := BreakerState{State: b.state, Failures: b.failures}
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		st.OpenedAt = &openedAt
+	}
+	return st
+}
@@ -0,0 +1,88 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChainPass is one prompt's verdict within an InspectionChain.
+type ChainPass struct {
+	Prompt      string   `json:"prompt"`
+	Score       int      `json:"score"`
+	RiskLevel   string   `json:"risk_level"`
+	Explanation string   `json:"explanation,omitempty"`
+	Categories  []string `json:"categories,omitempty"`
+}
+
+// inspectChain inspects content with every prompt of InspectionChain in
+// parallel, under one InspectorTimeoutSec deadline, and combines the verdicts:
+// the highest score decides, categories and matched rules are merged, and
+// explanations are joined. A failed pass fails the whole chain, since the
+// concern it covers went unchecked.
+func (ins *Inspector) inspectChain(ctx context.Context, cfg Config, content string) (*InspectionResult, error) {
+	if cfg.InspectorTimeoutSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.InspectorTimeoutSec)*time.Second)
+		defer cancel()
+	}
+
+	results := make([]*InspectionResult, len(cfg.InspectionChain))
+	errs := make([]error, len(cfg.InspectionChain))
+	var wg sync.WaitGroup
+	for i, name := range cfg.InspectionChain {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i], errs[i] = ins.inspect(ctx, content, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("chain prompt %s: %w", cfg.InspectionChain[i], err)
+		}
+	}
+
+	worst := results[0]
+	combined := &InspectionResult{}
+	var explanations []string
+	for i, r := range results {
+		name := cfg.InspectionChain[i]
+		combined.Chain = append(combined.Chain, ChainPass{
+			Prompt:      name,
+			Score:       r.Score,
+			RiskLevel:   r.RiskLevel,
+			Explanation: r.Explanation,
+			Categories:  r.Categories,
+		})
+		if r.Score > worst.Score {
+			worst = r
+		}
+		if r.Explanation != "" {
+			explanations = append(explanations, name+": "+r.Explanation)
+		}
+		combined.Categories = append(combined.Categories, r.Categories...)
+		combined.Spans = append(combined.Spans, r.Spans...)
+		for _, rule := range r.MatchedRules {
+			if !slices.Contains(combined.MatchedRules, rule) {
+				combined.MatchedRules = append(combined.MatchedRules, rule)
+			}
+		}
+		combined.PromptTokens += r.PromptTokens
+		combined.EvalTokens += r.EvalTokens
+	}
+	combined.Score = worst.Score
+	combined.RiskLevel = worst.RiskLevel
+	combined.RawRiskLevel = worst.RawRiskLevel
+	combined.Explanation = strings.Join(explanations, " | ")
+	combined.Categories = normalizeCategories(combined.Categories)
+	combined.Spans = normalizeSpans(combined.Spans)
+	combined.Raw = worst.Raw
+	combined.Confidence = worst.Confidence
+	return combined, nil
+}
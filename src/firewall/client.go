@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const clientIDHeader = "X-Client-ID"
+
+// clientID identifies the caller for per-client filtering and stats: the
+// X-Client-ID header if it is a valid ID, otherwise a short fingerprint of the
+// bearer API key, so keys are told apart without being stored. Requests with
+// neither are attributed to their IP (see resolveClientIP).
+func clientID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get(clientIDHeader)); validRequestID(id) && len(id) <= 64 {
+		return id
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key-" + hex.EncodeToString(sum[:6])
+	}
+	return clientIPFrom(r.Context())
+}
+
+// LogFilter selects logs by client and backend model. Empty fields match
+// every log.
+type LogFilter struct {
+	Client string
+	Model  string
+}
+
+func logFilterFrom(r *http.Request) LogFilter {
+	q := r.URL.Query()
+	return LogFilter{Client: q.Get("client"), Model: q.Get("model")}
+}
+
+func (f LogFilter) match(l InspectionLog) bool {
+	return (f.Client == "" || l.Client == f.Client) && (f.Model == "" || l.BackendModel == f.Model)
+}
+
+// Active reports whether the filter narrows anything.
+func (f LogFilter) Active() bool {
+	return f.Client != "" || f.Model != ""
+}
+
+func filterLogs(logs []InspectionLog, f LogFilter) []InspectionLog {
+	if !f.Active() {
+		return logs
+	}
+	out := []InspectionLog{}
+	for _, l := range logs {
+		if f.match(l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package firewall
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const inspectSeparator = "\n\n"
+
+// joinInspected joins the message texts to inspect. With DedupeMessages a
+// text seen earlier in the request is left out, so a preamble repeated every
+// turn is inspected once. With MaxInspectChars the oldest messages are
+// dropped until the rest fit; a newest message that alone is too long keeps
+// its end. Order is otherwise preserved.
+func joinInspected(cfg Config, parts []string) string {
+	if cfg.DedupeMessages {
+		seen := make(map[string]bool, len(parts))
+		kept := make([]string, 0, len(parts))
+		for _, p := range parts {
+			if !seen[p] {
+				seen[p] = true
+				kept = append(kept, p)
+			}
+		}
+		parts = kept
+	}
+	if cfg.MaxInspectChars <= 0 {
+		return strings.Join(parts, inspectSeparator)
+	}
+
+	budget := cfg.MaxInspectChars
+	first := len(parts)
+	for first > 0 {
+		n := utf8.RuneCountInString(parts[first-1])
+		if first < len(parts) {
+			n += len(inspectSeparator)
+		}
+		if n > budget {
+			break
+		}
+		budget -= n
+		first--
+	}
+	if first == len(parts) && first > 0 {
+		return tailRunes(parts[first-1], cfg.MaxInspectChars)
+	}
+	return strings.Join(parts[first:], inspectSeparator)
+}
+
+// tailRunes returns the last n runes of s.
+func tailRunes(s string, n int) string {
+	count := utf8.RuneCountInString(s)
+	for i := range s {
+		if count <= n {
+			return s[i:]
+		}
+		count--
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package firewall
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// contentRouteSkip as a ContentRoute action forwards matching content
+// without inspection.
+const contentRouteSkip = "skip"
+
+// ContentRoute sends content matching Pattern (a Go regular expression) past
+// the inspector (Action "skip") or to the prompt named by Action.
+type ContentRoute struct {
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+}
+
+// compiledRoutes caches compiled route patterns by pattern text.
+var compiledRoutes sync.Map
+
+func compileRoutePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledRoutes.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledRoutes.Store(pattern, re)
+	return re, nil
+}
+
+// matchContentRoute returns the first of ContentRoutes whose pattern matches
+// content, and a label for the log naming its position and action.
+func matchContentRoute(cfg Config, content string) (ContentRoute, string, bool) {
+	for i, route := range cfg.ContentRoutes {
+		re, err := compileRoutePattern(route.Pattern)
+		if err != nil || !re.MatchString(content) {
+			continue
+		}
+		return route, fmt.Sprintf("#%d %s", i+1, route.Action), true
+	}
+	return ContentRoute{}, "", false
+}
+
+func validateContentRoutes(routes []ContentRoute) error {
+	for i, route := range routes {
+		if route.Pattern == "" {
+			return fmt.Errorf("content_routes[%d]: pattern is required", i)
+		}
+		if _, err := compileRoutePattern(route.Pattern); err != nil {
+			return fmt.Errorf("content_routes[%d]: %w", i, err)
+		}
+		if _, ok := presetPrompts[route.Action]; !ok && route.Action != "custom" && route.Action != contentRouteSkip {
+			return fmt.Errorf("content_routes[%d]: action %q must be skip or a prompt name", i, route.Action)
+		}
+	}
+	return nil
+}
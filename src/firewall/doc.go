@@ -0,0 +1,20 @@
+// Package firewall is the AI Context Firewall: prompt injection inspection,
+// the Ollama-compatible proxy built on it, and the web UI. The firewall
+// binary is a thin command around this package; other Go programs can embed
+// the inspection directly:
+//
+//	cfg := firewall.DefaultConfig()
+//	cfg.InspectorURL = "http://localhost:11434"
+//	cfg.InspectorModel = "llama3.2:3b"
+//	store, err := firewall.NewMemoryStore(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	inspector := firewall.NewInspector(store)
+//	result, err := inspector.Inspect(ctx, content)
+//	if err == nil && result.Score >= cfg.Threshold {
+//		// reject the content
+//	}
+//
+// NewStore loads and persists a config file instead, as the binary does.
+package firewall
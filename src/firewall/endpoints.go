@@ -0,0 +1,81 @@
+package firewall
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultDeniedPaths are Ollama's model management endpoints. Proxied
+// unchecked, they let any client pull, overwrite or delete models.
+var defaultDeniedPaths = []string{
+	"/api/pull",
+	"/api/push",
+	"/api/create",
+	"/api/copy",
+	"/api/delete",
+	"/api/blobs/*",
+}
+
+// matchPath reports whether path matches pattern: exactly, or by prefix when
+// the pattern ends in "*".
+func matchPath(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == pattern
+}
+
+// pathAllowed applies the endpoint policy: a path is refused if it matches
+// DeniedPaths, or if AllowedPaths is set and the path matches none of them.
+func pathAllowed(cfg Config, path string) bool {
+	for _, p := range cfg.DeniedPaths {
+		if matchPath(p, path) {
+			return false
+		}
+	}
+	if len(cfg.AllowedPaths) == 0 {
+		return true
+	}
+	for _, p := range cfg.AllowedPaths {
+		if matchPath(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEndpoint rejects requests to endpoints the policy doesn't permit with
+// a 403 and reports whether the request may continue.
+func (p *Proxy) checkEndpoint(w http.ResponseWriter, r *http.Request) bool {
+	if pathAllowed(p.store.GetConfig(), r.URL.Path) {
+		return true
+	}
+	reqLogf(r.Context(), "DENIED %s %s: endpoint not permitted by policy", r.Method, r.URL.Path)
+	writeJSONError(w, http.StatusForbidden, errEndpointForbidden, "endpoint "+r.URL.Path+" is blocked by AI Context Firewall policy")
+	return false
+}
+
+// passthroughAllowed applies PassthroughPolicy to a path the proxy doesn't
+// inspect: with "deny" only PassthroughPaths are forwarded.
+func passthroughAllowed(cfg Config, path string) bool {
+	if cfg.PassthroughPolicy != "deny" {
+		return true
+	}
+	for _, p := range cfg.PassthroughPaths {
+		if matchPath(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassthrough rejects an uninspected request that PassthroughPolicy
+// doesn't permit with a 403 and reports whether it may be forwarded.
+func (p *Proxy) checkPassthrough(w http.ResponseWriter, r *http.Request) bool {
+	if passthroughAllowed(p.store.GetConfig(), r.URL.Path) {
+		return true
+	}
+	reqLogf(r.Context(), "DENIED %s %s: not in passthrough_paths", r.Method, r.URL.Path)
+	writeJSONError(w, http.StatusForbidden, errEndpointForbidden, "endpoint "+r.URL.Path+" is not permitted by AI Context Firewall passthrough policy")
+	return false
+}
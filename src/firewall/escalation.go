@@ -0,0 +1,42 @@
+package firewall
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// shouldEscalate reports whether a primary score is close enough to the
+// threshold, within EscalationBand either side, to ask EscalationModel.
+func (c Config) shouldEscalate(score int) bool {
+	return c.EscalationModel != "" && c.EscalationModel != c.InspectorModel && abs(score-c.Threshold) <= c.EscalationBand
+}
+
+// escalate re-inspects borderline content with the larger EscalationModel
+// and returns its verdict, keeping the primary score for the log. If the
+// escalation call fails, the primary verdict stands.
+func (ins *Inspector) escalate(ctx context.Context, cfg Config, content, systemPrompt string, primary *InspectionResult) *InspectionResult {
+	ctx, span := tracer.Start(ctx, "escalate", trace.WithAttributes(
+		attribute.String("firewall.escalation_model", cfg.EscalationModel),
+		attribute.Int("firewall.primary_score", primary.Score),
+	))
+	defer span.End()
+
+	escCfg := cfg
+	escCfg.InspectorModel = cfg.EscalationModel
+	result, err := ins.runModel(ctx, escCfg, content, systemPrompt)
+	if err != nil {
+		reqLogf(ctx, "escalation to %s failed, keeping primary score %d: %v", cfg.EscalationModel, primary.Score, err)
+		span.RecordError(err)
+		return primary
+	}
+	reqLogf(ctx, "escalated borderline score %d to %s: score %d", primary.Score, cfg.EscalationModel, result.Score)
+
+	primaryScore := primary.Score
+	result.PrimaryScore = &primaryScore
+	result.EscalationModel = cfg.EscalationModel
+	result.PromptTokens += primary.PromptTokens
+	result.EvalTokens += primary.EvalTokens
+	return result
+}
@@ -0,0 +1,164 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	forwardedForHeader   = "X-Forwarded-For"
+	forwardedHostHeader  = "X-Forwarded-Host"
+	forwardedProtoHeader = "X-Forwarded-Proto"
+)
+
+// trustedUnix in TrustedProxies trusts peers on a unix socket listener, which
+// have no IP address.
+const trustedUnix = "unix"
+
+type clientIPKey struct{}
+
+// withClientIP stores the client's IP (see resolveClientIP) in the request
+// context, for logs and client attribution.
+func withClientIP(r *http.Request, cfg Config) *http.Request {
+	ip := resolveClientIP(r, cfg.TrustedProxies)
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+func clientIPFrom(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// resolveClientIP returns the IP the request came from. Behind a trusted
+// proxy that is the right-most X-Forwarded-For entry that isn't a trusted
+// proxy itself; anyone else's X-Forwarded-For is ignored, so a client
+// talking to the firewall directly can't spoof its address.
+func resolveClientIP(r *http.Request, trusted []string) string {
+	remote := remoteIP(r)
+	if !isTrustedProxy(remote, trusted) {
+		return remote
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !isTrustedProxy(hops[i], trusted) {
+			return hops[i]
+		}
+	}
+	if len(hops) > 0 {
+		return hops[0]
+	}
+	return remote
+}
+
+// remoteIP is the IP of the connection's peer, empty on a unix socket.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// forwardedFor lists the X-Forwarded-For entries, client first.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values(forwardedForHeader) {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// isTrustedProxy reports whether ip matches an address or CIDR range of
+// TrustedProxies. An empty ip is a unix socket peer.
+func isTrustedProxy(ip string, trusted []string) bool {
+	if ip == "" {
+		for _, t := range trusted {
+			if t == trustedUnix {
+				return true
+			}
+		}
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, t := range trusted {
+		if prefix, err := netip.ParsePrefix(t); err == nil && prefix.Contains(addr) {
+			return true
+		}
+		if a, err := netip.ParseAddr(t); err == nil && a.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwardedHeaders sets X-Forwarded-For, -Host and -Proto on a request to
+// the backend. The values a client sent are built on only when it is a
+// trusted proxy, and replaced otherwise. Headers the request header policy
+// doesn't permit are left out.
+func setForwardedHeaders(dst http.Header, r *http.Request, cfg Config) {
+	policy := cfg.requestHeaderPolicy()
+	remote := remoteIP(r)
+	trusted := isTrustedProxy(remote, cfg.TrustedProxies)
+	dst.Del(forwardedForHeader)
+	dst.Del(forwardedHostHeader)
+	dst.Del(forwardedProtoHeader)
+
+	if policy.permits(forwardedForHeader) {
+		var hops []string
+		if trusted {
+			hops = forwardedFor(r.Header)
+		}
+		if remote != "" {
+			hops = append(hops, remote)
+		}
+		if len(hops) > 0 {
+			dst.Set(forwardedForHeader, strings.Join(hops, ", "))
+		}
+	}
+	if policy.permits(forwardedHostHeader) {
+		host := r.Host
+		if v := r.Header.Get(forwardedHostHeader); trusted && v != "" {
+			host = v
+		}
+		dst.Set(forwardedHostHeader, host)
+	}
+	if policy.permits(forwardedProtoHeader) {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		if v := r.Header.Get(forwardedProtoHeader); trusted && v != "" {
+			proto = v
+		}
+		dst.Set(forwardedProtoHeader, proto)
+	}
+}
+
+// validateTrustedProxies checks that every entry is an IP, a CIDR range or
+// "unix".
+func validateTrustedProxies(trusted []string) error {
+	for _, t := range trusted {
+		if t == trustedUnix {
+			continue
+		}
+		if _, err := netip.ParsePrefix(t); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(t); err != nil {
+			return fmt.Errorf("trusted_proxies: %q must be an IP address, a CIDR range or %q", t, trustedUnix)
+		}
+	}
+	return nil
+}
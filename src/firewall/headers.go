@@ -0,0 +1,89 @@
+package firewall
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders describe a single connection (RFC 7230 section 6.1) and are
+// never relayed between client and backend.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// headerPolicy decides which end-to-end headers are relayed: none matching
+// deny, and when allow is set only those matching it. Patterns match header
+// names case-insensitively, by prefix when they end in "*".
+type headerPolicy struct {
+	allow, deny []string
+}
+
+func (hp headerPolicy) permits(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range hp.deny {
+		if matchPath(strings.ToLower(p), name) {
+			return false
+		}
+	}
+	if len(hp.allow) == 0 {
+		return true
+	}
+	for _, p := range hp.allow {
+		if matchPath(strings.ToLower(p), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) requestHeaderPolicy() headerPolicy {
+	return headerPolicy{allow: c.AllowedRequestHeaders, deny: c.DeniedRequestHeaders}
+}
+
+func (c Config) responseHeaderPolicy() headerPolicy {
+	return headerPolicy{allow: c.AllowedResponseHeaders, deny: c.DeniedResponseHeaders}
+}
+
+// copyHeaders adds the headers of src to dst that may be relayed: not
+// hop-by-hop, not named in src's Connection header, and permitted by policy.
+func copyHeaders(dst, src http.Header, policy headerPolicy) {
+	hop := map[string]bool{}
+	for _, h := range hopByHopHeaders {
+		hop[h] = true
+	}
+	for _, v := range src.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				hop[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	for key, values := range src {
+		if hop[http.CanonicalHeaderKey(key)] || !policy.permits(key) {
+			continue
+		}
+		for _, v := range values {
+			dst.Add(key, v)
+		}
+	}
+}
+
+// validateHeaderPatterns checks a header allow or deny list.
+func validateHeaderPatterns(field string, patterns []string) error {
+	for _, p := range patterns {
+		name, _ := strings.CutSuffix(p, "*")
+		if name == "" && p != "*" || strings.ContainsAny(name, " \t:*") {
+			return fmt.Errorf("%s: %q is not a header name or prefix*", field, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package firewall
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// inspectorProbeInterval is how often the inspector is re-probed after
+// startup, so a recovered (or lost) inspector shows up on the dashboard and
+// at /readyz without a restart.
+const inspectorProbeInterval = 30 * time.Second
+
+// InspectorHealth is the outcome of the last inspector probe, served at
+// /readyz and shown as a banner on the dashboard while not ready.
+type InspectorHealth struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+	// Effect tells what happens to requests meanwhile, per fail_mode.
+	Effect    string    `json:"effect,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// healthMonitor keeps the last inspector probe.
+type healthMonitor struct {
+	mu      sync.Mutex
+	health  InspectorHealth
+	started sync.Once
+}
+
+// probeInspector checks that the inspector host answers and has the
+// inspector model. Heuristic mode needs neither.
+func probeInspector(cfg Config) error {
+	if cfg.InspectorMode == "heuristic" || cfg.InspectorModel == "" {
+		return nil
+	}
+	key := modelListKey{url: cfg.InspectorURL, openAI: cfg.InspectorType == "openai"}
+	models, err := fetchModelList(key, cfg.InspectorAPIKey)
+	if err != nil {
+		return err
+	}
+	if !modelListed(models, cfg.InspectorModel) {
+		return &ModelNotFoundError{Role: "inspector", Model: cfg.InspectorModel, URL: cfg.InspectorURL}
+	}
+	return nil
+}
+
+// degradedEffect describes what fail_mode does to requests while the
+// inspector is unavailable.
+func degradedEffect(cfg Config) string {
+	if cfg.FailMode == "closed" {
+		return "every request is blocked (fail_mode closed)"
+	}
+	return "every request is forwarded without inspection (fail_mode open)"
+}
+
+// check probes the inspector and records the result, logging loudly when it
+// becomes unavailable and once more when it recovers.
+func (hm *healthMonitor) check(cfg Config) {
+	err := probeInspector(cfg)
+	h := InspectorHealth{Ready: err == nil, CheckedAt: time.Now()}
+	if err != nil {
+		h.Error = err.Error()
+		h.Effect = degradedEffect(cfg)
+	}
+
+	hm.mu.Lock()
+	prev := hm.health
+	hm.health = h
+	hm.mu.Unlock()
+
+	first := prev.CheckedAt.IsZero()
+	switch {
+	case !h.Ready && (first || prev.Ready):
+		log.Printf("WARNING: ============================================================")
+		log.Printf("WARNING: inspector unavailable: %s", h.Error)
+		log.Printf("WARNING: requests are NOT being inspected: %s", h.Effect)
+		log.Printf("WARNING: ============================================================")
+	case h.Ready && !first && !prev.Ready:
+		log.Printf("inspector available again at %s", cfg.InspectorURL)
+	}
+}
+
+// get returns the last probe; before the first one the inspector counts as
+// ready, since nothing is known against it.
+func (hm *healthMonitor) get() InspectorHealth {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if hm.health.CheckedAt.IsZero() {
+		return InspectorHealth{Ready: true}
+	}
+	return hm.health
+}
+
+// monitor re-probes the inspector with the current config until the process
+// exits.
+func (hm *healthMonitor) monitor(store *Store) {
+	hm.started.Do(func() {
+		go func() {
+			for range time.Tick(inspectorProbeInterval) {
+				hm.check(store.GetConfig())
+			}
+		}()
+	})
+}
+
+// Health reports whether the inspector was reachable, with its model, at the
+// last probe.
+func (ins *Inspector) Health() InspectorHealth {
+	return ins.health.get()
+}
@@ -0,0 +1,157 @@
+package firewall
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// HeuristicRule adds Weight to the heuristic score when Pattern, a
+// case-insensitive regular expression, matches the content.
+type HeuristicRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Weight  int    `json:"weight"`
+}
+
+// defaultHeuristicRules are used when HeuristicRules is empty. They cover the
+// common phrasings of instruction overrides, prompt extraction and role-play
+// jailbreaks; a single strong match is enough to reach the default threshold.
+var defaultHeuristicRules = []HeuristicRule{
+	{Name: "ignore-instructions", Pattern: `\b(ignore|disregard|forget|override|bypass)\b.{0,30}\b(previous|prior|above|earlier|all|any|your|the)\b.{0,30}\b(instructions?|rules|prompts?|guidelines|directions|context)`, Weight: 70},
+	{Name: "new-instructions", Pattern: `\b(new|updated|real|actual)\s+(instructions?|system\s+prompt|rules)\s*:`, Weight: 50},
+	{Name: "system-prompt-extraction", Pattern: `\b(reveal|print|show|output|repeat|leak|display)\b.{0,40}\b(system\s+prompt|initial\s+instructions|hidden\s+(prompt|instructions)|your\s+instructions)`, Weight: 70},
+	{Name: "role-override", Pattern: `\b(you\s+are\s+now|from\s+now\s+on\s+you\s+are|act\s+as\s+(an?\s+)?(unrestricted|unfiltered|jailbroken))\b`, Weight: 40},
+	{Name: "jailbreak-persona", Pattern: `(?-i:\bDAN\b)|\b(do\s+anything\s+now|developer\s+mode|jailbreak(ed)?|god\s+mode)\b`, Weight: 50},
+	{Name: "fake-system-message", Pattern: `(^|\n)\s*(\[?system\]?|###\s*system|<\|?system\|?>|<\|im_start\|>\s*system)\s*[:>\n]`, Weight: 40},
+	{Name: "safety-bypass", Pattern: `\b(without|no|ignore|disable)\s+(any\s+)?(restrictions|filters|safety|guardrails|censorship)\b`, Weight: 30},
+	{Name: "exfiltration", Pattern: `\b(send|post|upload|forward|exfiltrate)\b.{0,40}\b(to|via)\b.{0,20}(https?://|webhook|email|@)`, Weight: 40},
+	{Name: "hidden-instruction-markup", Pattern: `<!--.{0,200}\b(instruction|ignore|assistant|ai)\b.{0,200}-->`, Weight: 40},
+}
+
+const (
+	// Weights of the built-in encoding checks
+	encodedPayloadWeight   = 20
+	invisibleCharsWeight   = 30
+	minInvisibleCharsFlag  = 3
+	decodedPrintableMinPct = 90
+)
+
+// reBase64 finds runs long enough to hide a sentence.
+var reBase64 = regexp.MustCompile(`[A-Za-z0-9+/]{24,}={0,2}`)
+
+// compiledRules caches compiled rule patterns by pattern text.
+var compiledRules sync.Map
+
+func compileRule(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledRules.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile("(?is)" + pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledRules.Store(pattern, re)
+	return re, nil
+}
+
+// HeuristicRules returns the configured rules, or the built-in ones.
+func HeuristicRules(cfg Config) []HeuristicRule {
+	if len(cfg.HeuristicRules) > 0 {
+		return cfg.HeuristicRules
+	}
+	return defaultHeuristicRules
+}
+
+// heuristicInspect scores content by pattern matching alone: the weights of
+// all matching rules are summed and capped at 100. Base64 payloads are
+// decoded and matched as well, and both they and clusters of invisible
+// characters add to the score on their own.
+func heuristicInspect(cfg Config, content string) *InspectionResult {
+	var findings []string
+	score := 0
+	// Counted before normalization, which strips them
+	if n := countInvisible(content); n >= minInvisibleCharsFlag {
+		score += invisibleCharsWeight
+		findings = append(findings, fmt.Sprintf("invisible characters (%d)", n))
+	}
+	if cfg.NormalizeUnicode {
+		content = normalizeForInspection(content, cfg.MapHomoglyphs)
+	}
+	texts := []string{content}
+
+	decoded := decodeBase64Payloads(content)
+	if len(decoded) > 0 {
+		texts = append(texts, decoded...)
+		score += encodedPayloadWeight
+		findings = append(findings, fmt.Sprintf("base64 payload (%d)", len(decoded)))
+	}
+
+	for _, rule := range HeuristicRules(cfg) {
+		re, err := compileRule(rule.Pattern)
+		if err != nil {
+			// Rejected by Validate; only reachable with a hand-edited file
+			continue
+		}
+		for _, text := range texts {
+			if re.MatchString(text) {
+				score += rule.Weight
+				findings = append(findings, rule.Name)
+				break
+			}
+		}
+	}
+	score = max(0, min(score, 100))
+
+	explanation := "No heuristic rule matched"
+	if len(findings) > 0 {
+		sort.Strings(findings)
+		explanation = "Matched: " + strings.Join(findings, ", ")
+	}
+	return &InspectionResult{
+		RiskLevel:   riskLevelFor(cfg, score),
+		Score:       score,
+		Explanation: explanation,
+	}
+}
+
+// decodeBase64Payloads returns the decoded text of base64 runs in content
+// that decode to mostly printable text.
+func decodeBase64Payloads(content string) []string {
+	var decoded []string
+	for _, m := range reBase64.FindAllString(content, -1) {
+		data, err := base64.StdEncoding.DecodeString(m)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(m, "="))
+		}
+		if err != nil || !utf8.Valid(data) {
+			continue
+		}
+		text := string(data)
+		printable := 0
+		for _, r := range text {
+			if unicode.IsPrint(r) || unicode.IsSpace(r) {
+				printable++
+			}
+		}
+		if printable*100 >= utf8.RuneCountInString(text)*decodedPrintableMinPct {
+			decoded = append(decoded, text)
+		}
+	}
+	return decoded
+}
+
+func countInvisible(content string) int {
+	n := 0
+	for _, r := range content {
+		if isInvisible(r) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,243 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// inspectorReply is the backend-independent part of an inspector response.
+type inspectorReply struct {
+	Content      string
+	PromptTokens int
+	EvalTokens   int
+	// Early is the verdict a streamed reply was cut short at, if any;
+	// Content then holds only what had arrived.
+	Early *InspectionResult
+}
+
+// inspectorClient covers the bits of an inspector call that differ between
+// API flavors: endpoint path, request body shape, and response shape.
+type inspectorClient interface {
+	path() string
+	requestBody(cfg Config, messages []map[string]string) map[string]any
+	decodeReply(r io.Reader) (inspectorReply, error)
+}
+
+func newInspectorClient(inspectorType string) inspectorClient {
+	if inspectorType == "openai" {
+		return openAIClient{}
+	}
+	return ollamaClient{}
+}
+
+func chatMessages(systemPrompt, content string) []map[string]string {
+	return []map[string]string{
+		{"role": "system", "content": systemPrompt},
+		{"role": "user", "content": content},
+	}
+}
+
+// ollamaClient talks to Ollama's native /api/chat.
+type ollamaClient struct{}
+
+func (ollamaClient) path() string { return "/api/chat" }
+
+// defaultInspectorNumCtx is the default inspector context window in tokens.
+// Ollama's own default is small enough that long prompts get cut without an
+// error, and the cut part is never inspected.
+const defaultInspectorNumCtx = 8192
+
+// dedicatedInspectorOptions are the Ollama options that have their own
+// config field, which InspectorOptions can't override.
+var dedicatedInspectorOptions = map[string]string{
+	"num_predict": "max_inspect_tokens",
+	"temperature": "inspector_temperature",
+	"seed":        "inspector_seed",
+}
+
+func (ollamaClient) requestBody(cfg Config, messages []map[string]string) map[string]any {
+	opts := maps.Clone(cfg.InspectorOptions)
+	if opts == nil {
+		opts = map[string]any{}
+	}
+	// A partial config update merges into the map, so null is how an option
+	// such as the default num_ctx gets unset
+	maps.DeleteFunc(opts, func(_ string, v any) bool { return v == nil })
+	// Temperature defaults to 0 so verdicts are stable; together with a fixed
+	// seed the same content always gets the same verdict.
+	opts["num_predict"] = cfg.MaxInspectTokens
+	opts["temperature"] = cfg.InspectorTemperature
+	if cfg.InspectorSeed != 0 {
+		opts["seed"] = cfg.InspectorSeed
+	}
+	body := map[string]any{
+		"model":    cfg.InspectorModel,
+		"messages": messages,
+		"stream":   cfg.StreamInspector,
+		"format":   "json",
+		"options":  opts,
+	}
+	if keepAlive, _ := cfg.inspectorKeepAlive(); keepAlive != nil {
+		body["keep_alive"] = keepAlive
+	}
+	return body
+}
+
+// inspectorKeepAlive returns InspectorKeepAlive as Ollama's keep_alive
+// value: a number of seconds or a duration string, negative keeping the model
+// loaded indefinitely. It returns nil when unset, leaving Ollama's default.
+func (c Config) inspectorKeepAlive() (any, error) {
+	if c.InspectorKeepAlive == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(c.InspectorKeepAlive); err == nil {
+		return n, nil
+	}
+	if _, err := time.ParseDuration(c.InspectorKeepAlive); err != nil {
+		return nil, fmt.Errorf("inspector_keep_alive: %q must be seconds or a duration like 30m or -1", c.InspectorKeepAlive)
+	}
+	return c.InspectorKeepAlive, nil
+}
+
+func (ollamaClient) decodeReply(r io.Reader) (inspectorReply, error) {
+	var resp struct {
+		Message *struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return inspectorReply{}, fmt.Errorf("read inspector response: %w", err)
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return inspectorReply{}, &UnexpectedReplyError{Problem: "invalid JSON", Snippet: replySnippet(data)}
+	}
+	if resp.Message == nil {
+		return inspectorReply{}, &UnexpectedReplyError{Problem: `JSON without a "message"`, Snippet: replySnippet(data)}
+	}
+	return inspectorReply{
+		Content:      resp.Message.Content,
+		PromptTokens: resp.PromptEvalCount,
+		EvalTokens:   resp.EvalCount,
+	}, nil
+}
+
+// openAIClient talks to an OpenAI-compatible /v1/chat/completions endpoint.
+type openAIClient struct{}
+
+func (openAIClient) path() string { return "/v1/chat/completions" }
+
+func (openAIClient) requestBody(cfg Config, messages []map[string]string) map[string]any {
+	body := map[string]any{
+		"model":           cfg.InspectorModel,
+		"messages":        messages,
+		"stream":          false,
+		"max_tokens":      cfg.MaxInspectTokens,
+		"temperature":     cfg.InspectorTemperature,
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	if cfg.InspectorSeed != 0 {
+		body["seed"] = cfg.InspectorSeed
+	}
+	return body
+}
+
+func (openAIClient) decodeReply(r io.Reader) (inspectorReply, error) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return inspectorReply{}, fmt.Errorf("read inspector response: %w", err)
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return inspectorReply{}, &UnexpectedReplyError{Problem: "invalid JSON", Snippet: replySnippet(data)}
+	}
+	if len(resp.Choices) == 0 {
+		return inspectorReply{}, &UnexpectedReplyError{Problem: `JSON without "choices"`, Snippet: replySnippet(data)}
+	}
+	return inspectorReply{
+		Content:      resp.Choices[0].Message.Content,
+		PromptTokens: resp.Usage.PromptTokens,
+		EvalTokens:   resp.Usage.CompletionTokens,
+	}, nil
+}
+
+// replySnippetBytes is how much of an unexpected inspector reply is quoted in
+// the error.
+const replySnippetBytes = 200
+
+// UnexpectedReplyError is a 200 reply from the inspector that isn't the JSON
+// its API returns. Most often it is an HTML page because InspectorURL points
+// at a reverse proxy, a login page or some other web server instead of the
+// inspector API.
+type UnexpectedReplyError struct {
+	URL         string
+	ContentType string
+	Problem     string // e.g. "non-JSON (got HTML?)"
+	Snippet     string // the start of the body
+}
+
+func (e *UnexpectedReplyError) Error() string {
+	return fmt.Sprintf("inspector returned %s with status 200 from %s (Content-Type %q): %q; check that inspector_url points at the inspector API",
+		e.Problem, e.URL, e.ContentType, e.Snippet)
+}
+
+// checkReplyJSON looks at the start of a 200 reply, without consuming it,
+// and rejects one that isn't a JSON object before it is decoded.
+func checkReplyJSON(body *bufio.Reader) error {
+	// The first Peek waits for data; what else has arrived is looked at
+	// without blocking, so a streamed reply isn't held up
+	if _, err := body.Peek(1); err != nil && err != io.EOF {
+		return fmt.Errorf("read inspector response: %w", err)
+	}
+	head, _ := body.Peek(body.Buffered())
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return nil
+	}
+	problem := "non-JSON"
+	switch {
+	case len(head) == 0:
+		problem = "an empty body"
+	case bytes.HasPrefix(bytes.ToLower(trimmed), []byte("<!doctype html")) || bytes.HasPrefix(bytes.ToLower(trimmed), []byte("<html")):
+		problem = "non-JSON (got HTML?)"
+	}
+	data, _ := io.ReadAll(io.LimitReader(body, replySnippetBytes+1))
+	return &UnexpectedReplyError{Problem: problem, Snippet: replySnippet(data)}
+}
+
+// replySnippet is the start of a reply body for an error message, cut at
+// replySnippetBytes on a rune boundary with whitespace runs collapsed.
+func replySnippet(data []byte) string {
+	cut := len(data) > replySnippetBytes
+	if cut {
+		data = data[:replySnippetBytes]
+		for len(data) > 0 && !utf8.Valid(data) {
+			data = data[:len(data)-1]
+		}
+	}
+	s := strings.Join(strings.Fields(string(data)), " ")
+	if cut {
+		s += "..."
+	}
+	return s
+}
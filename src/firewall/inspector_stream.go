@@ -0,0 +1,88 @@
+package firewall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// streamingClient is an inspectorClient that can read its reply as it is
+// generated, used with StreamInspector.
+type streamingClient interface {
+	decodeStream(r io.Reader, schema InspectorSchema) (inspectorReply, error)
+}
+
+// decodeStream reads Ollama's NDJSON chunks and stops as soon as the verdict
+// is decided (see earlyVerdict) or the JSON object is closed; with "format":
+// "json" some models keep emitting whitespace after the object until the
+// token limit. Returning closes the body, which makes Ollama stop generating.
+// A reply that turns out not to be streamed at all is a single final chunk.
+func (ollamaClient) decodeStream(r io.Reader, schema InspectorSchema) (inspectorReply, error) {
+	var reply inspectorReply
+	var content strings.Builder
+	dec := json.NewDecoder(r)
+	for {
+		var chunk struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			Done            bool `json:"done"`
+			PromptEvalCount int  `json:"prompt_eval_count"`
+			EvalCount       int  `json:"eval_count"`
+		}
+		if err := dec.Decode(&chunk); err != nil {
+			if errors.Is(err, io.EOF) && content.Len() > 0 {
+				break
+			}
+			return inspectorReply{}, fmt.Errorf("decode inspector stream: %w", err)
+		}
+		content.WriteString(chunk.Message.Content)
+		if chunk.Done {
+			reply.PromptTokens, reply.EvalTokens = chunk.PromptEvalCount, chunk.EvalCount
+			break
+		}
+		// Ollama reports token counts only in the final chunk; until then
+		// each chunk is one token
+		reply.EvalTokens++
+		if verdict, ok := earlyVerdict(content.String(), schema); ok {
+			reply.Early = &verdict
+			break
+		}
+		if strings.Contains(chunk.Message.Content, "}") && closedObject(content.String()) {
+			break
+		}
+	}
+	reply.Content = content.String()
+	return reply, nil
+}
+
+// earlyVerdict parses a reply that is still arriving, succeeding once the
+// score and every required field of schema are complete. Fields still being
+// written, such as a half-streamed explanation, are left out.
+func earlyVerdict(partial string, schema InspectorSchema) (InspectionResult, bool) {
+	var result InspectionResult
+	found := map[string]bool{}
+	for _, field := range schemaFields {
+		loc := fieldRegex(field, schema.name(field)).FindStringSubmatchIndex(partial)
+		if loc == nil || !valueEnded(partial, loc[1]) {
+			continue
+		}
+		setMatchedField(field, partial[loc[2]:loc[3]], &result)
+		found[field] = true
+	}
+	return result, found[fieldScore] && schema.complete(found)
+}
+
+// valueEnded reports whether a match ending at end is followed by more of the
+// reply, so a score of 8 isn't taken while 85 is arriving.
+func valueEnded(partial string, end int) bool {
+	return end < len(partial) && !strings.ContainsRune("0123456789.", rune(partial[end]))
+}
+
+// closedObject reports whether the reply so far is a complete JSON object.
+func closedObject(partial string) bool {
+	s := strings.TrimSpace(partial)
+	return strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") && json.Valid([]byte(s))
+}
@@ -0,0 +1,74 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+const defaultExplanationLanguage = "English"
+
+// defaultBlockTemplates are the built-in block messages by language. The
+// score is left out when there is no verdict, e.g. failing closed.
+var defaultBlockTemplates = map[string]string{
+	"English": "[BLOCKED by AI Context Firewall] {{if ge .Score 0}}Risk score: {{.Score}}/100 ({{.RiskLevel}}). {{end}}{{.Explanation}}",
+	"German":  "[BLOCKIERT durch AI Context Firewall] {{if ge .Score 0}}Risikobewertung: {{.Score}}/100 ({{.RiskLevel}}). {{end}}{{.Explanation}}",
+	"French":  "[BLOQUÉ par AI Context Firewall] {{if ge .Score 0}}Score de risque : {{.Score}}/100 ({{.RiskLevel}}). {{end}}{{.Explanation}}",
+	"Spanish": "[BLOQUEADO por AI Context Firewall] {{if ge .Score 0}}Puntuación de riesgo: {{.Score}}/100 ({{.RiskLevel}}). {{end}}{{.Explanation}}",
+}
+
+// explanationLanguage is the language inspector explanations and block
+// messages are requested in.
+func (c Config) explanationLanguage() string {
+	if c.ExplanationLanguage == "" {
+		return defaultExplanationLanguage
+	}
+	return c.ExplanationLanguage
+}
+
+// blockTemplate picks the block message for the explanation language:
+// a configured one, then a built-in one, then the English default. Language
+// names match case-insensitively.
+func (c Config) blockTemplate() string {
+	lang := c.explanationLanguage()
+	for _, templates := range []map[string]string{c.BlockTemplates, defaultBlockTemplates} {
+		for name, tmpl := range templates {
+			if strings.EqualFold(name, lang) {
+				return tmpl
+			}
+		}
+	}
+	return defaultBlockTemplates[defaultExplanationLanguage]
+}
+
+// renderBlockMessage fills the block template with the inspection result. A
+// broken template falls back to the English default so the client is still
+// told why the request was refused.
+func renderBlockMessage(cfg Config, result *InspectionResult) string {
+	var buf bytes.Buffer
+	t, err := template.New("block").Parse(cfg.blockTemplate())
+	if err == nil {
+		err = t.Execute(&buf, result)
+	}
+	if err != nil {
+		log.Printf("block template for %s failed, using default: %v", cfg.explanationLanguage(), err)
+		buf.Reset()
+		template.Must(template.New("block").Parse(defaultBlockTemplates[defaultExplanationLanguage])).Execute(&buf, result)
+	}
+	return buf.String()
+}
+
+func validateBlockTemplates(templates map[string]string) error {
+	for lang, tmpl := range templates {
+		t, err := template.New("block").Parse(tmpl)
+		if err == nil {
+			err = t.Execute(&bytes.Buffer{}, &InspectionResult{RiskLevel: "malicious", Score: 90, Explanation: "example"})
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", lang, err)
+		}
+	}
+	return nil
+}
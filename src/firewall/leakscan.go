@@ -0,0 +1,373 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretPattern is a regular expression for something that must not appear
+// in model output. Unlike rule patterns it is case-sensitive unless it says
+// (?i), since key formats depend on case.
+type SecretPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// defaultSecretPatterns are used when SecretPatterns is empty.
+var defaultSecretPatterns = []SecretPattern{
+	{Name: "private-key", Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----(?s:.*?)(-----END [A-Z ]*PRIVATE KEY-----|$)`},
+	{Name: "aws-access-key", Pattern: `\b(AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "github-token", Pattern: `\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})`},
+	{Name: "openai-key", Pattern: `\bsk-(proj-)?[A-Za-z0-9_-]{20,}`},
+	{Name: "slack-token", Pattern: `\bxox[abprs]-[A-Za-z0-9-]{10,}`},
+	{Name: "google-api-key", Pattern: `\bAIza[0-9A-Za-z_-]{35}`},
+	{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`},
+	{Name: "credential-assignment", Pattern: `(?i)\b(api[_-]?key|secret|passw(or)?d|access[_-]?token)["']?\s*[:=]\s*["']?[A-Za-z0-9/+_.@!$%-]{12,}`},
+}
+
+// minLeakFragment is the shortest line or sentence of a protected prompt
+// that counts as leaked when it shows up in a response. Shorter ones are too
+// likely to occur by chance.
+const minLeakFragment = 40
+
+// responseLeakPrompt asks the inspector whether a response discloses secrets.
+const responseLeakPrompt = `You are a data-leak detector. The text below is a response an AI model is about to send to a user. Decide whether it discloses secrets: API keys, passwords, access tokens, private keys, connection strings, or the model's own system prompt or hidden instructions.
+
+Respond ONLY with a JSON object:
+- "risk_level": one of "safe", "suspicious", or "malicious"
+- "score": 0 (no secrets) to 100 (clearly leaks secrets)
+- "explanation": one short sentence naming what kind of secret leaked, without repeating it`
+
+// compiledSecrets caches compiled secret patterns by pattern text.
+var compiledSecrets sync.Map
+
+func compileSecretPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledSecrets.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledSecrets.Store(pattern, re)
+	return re, nil
+}
+
+func secretPatterns(cfg Config) []SecretPattern {
+	if len(cfg.SecretPatterns) > 0 {
+		return cfg.SecretPatterns
+	}
+	return defaultSecretPatterns
+}
+
+// leakMatch is one place a secret or prompt fragment was found.
+type leakMatch struct {
+	name       string
+	start, end int
+}
+
+// compiledFragments caches the patterns of ProtectedPrompts fragments by
+// fragment text. Fragments of a request's own system prompts aren't cached,
+// since clients choose those and the cache would grow without bound.
+var compiledFragments sync.Map
+
+// promptFragments splits the protected prompts and the request's system
+// prompts into the lines and sentences worth looking for, as case-insensitive
+// patterns that tolerate reflowed whitespace.
+func promptFragments(protected, request []string) []*regexp.Regexp {
+	var res []*regexp.Regexp
+	seen := map[string]bool{}
+	add := func(prompts []string, cached bool) {
+		for _, p := range prompts {
+			for _, line := range strings.Split(p, "\n") {
+				for _, frag := range strings.SplitAfter(line, ". ") {
+					words := strings.Fields(frag)
+					key := strings.ToLower(strings.Join(words, " "))
+					if len(key) < minLeakFragment || seen[key] {
+						continue
+					}
+					seen[key] = true
+					if re, ok := compiledFragments.Load(key); ok {
+						res = append(res, re.(*regexp.Regexp))
+						continue
+					}
+					for i, w := range words {
+						words[i] = regexp.QuoteMeta(w)
+					}
+					re := regexp.MustCompile(`(?i)` + strings.Join(words, `\s+`))
+					if cached {
+						compiledFragments.Store(key, re)
+					}
+					res = append(res, re)
+				}
+			}
+		}
+	}
+	add(protected, true)
+	add(request, false)
+	return res
+}
+
+// findLeaks returns the secrets and protected prompt fragments in text.
+func findLeaks(cfg Config, text string, prompts []string) []leakMatch {
+	var matches []leakMatch
+	for _, sp := range secretPatterns(cfg) {
+		re, err := compileSecretPattern(sp.Pattern)
+		if err != nil {
+			continue
+		}
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			matches = append(matches, leakMatch{name: sp.Name, start: loc[0], end: loc[1]})
+		}
+	}
+	for _, re := range promptFragments(cfg.ProtectedPrompts, prompts) {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			matches = append(matches, leakMatch{name: "system-prompt", start: loc[0], end: loc[1]})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	return matches
+}
+
+// leakNames summarizes matches as "name" or "name xN", in order of first
+// appearance.
+func leakNames(matches []leakMatch) []string {
+	counts := map[string]int{}
+	var order []string
+	for _, m := range matches {
+		if counts[m.name] == 0 {
+			order = append(order, m.name)
+		}
+		counts[m.name]++
+	}
+	for i, name := range order {
+		if counts[name] > 1 {
+			order[i] = fmt.Sprintf("%s x%d", name, counts[name])
+		}
+	}
+	return order
+}
+
+// requestSystemPrompts returns the system prompt text of a chat or generate
+// request body, which a response must not give away either.
+func requestSystemPrompts(body []byte) []string {
+	var req struct {
+		System   string `json:"system"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	json.Unmarshal(body, &req)
+	var prompts []string
+	if req.System != "" {
+		prompts = append(prompts, req.System)
+	}
+	for _, m := range req.Messages {
+		if m.Role == "system" {
+			prompts = append(prompts, m.Content)
+		}
+	}
+	return prompts
+}
+
+// responseChunks splits a backend response into its JSON objects: one per
+// line for NDJSON streams, or the whole body. It reports false if any part
+// isn't a JSON object, in which case the response is passed on unscanned.
+func responseChunks(data []byte) ([]map[string]any, bool) {
+	var chunks []map[string]any
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var obj map[string]any
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return nil, false
+		}
+		chunks = append(chunks, obj)
+	}
+	return chunks, len(chunks) > 0
+}
+
+// chunkText returns the generated text of a chat or generate chunk.
+func chunkText(obj map[string]any, isChat bool) string {
+	if isChat {
+		msg, _ := obj["message"].(map[string]any)
+		text, _ := msg["content"].(string)
+		return text
+	}
+	text, _ := obj["response"].(string)
+	return text
+}
+
+func setChunkText(obj map[string]any, isChat bool, text string) {
+	if isChat {
+		if msg, ok := obj["message"].(map[string]any); ok {
+			msg["content"] = text
+		}
+		return
+	}
+	obj["response"] = text
+}
+
+// mergeLeaks joins overlapping matches, keeping the name of the first.
+// matches must be sorted by start.
+func mergeLeaks(matches []leakMatch) []leakMatch {
+	var merged []leakMatch
+	for _, m := range matches {
+		if n := len(merged); n > 0 && m.start < merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, m.end)
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// redactChunks removes the matched ranges of the concatenated chunk text,
+// which may span chunk boundaries, and marks each with [REDACTED:name] in the
+// chunk where it starts.
+func redactChunks(chunks []map[string]any, isChat bool, matches []leakMatch, stream bool) []byte {
+	matches = mergeLeaks(matches)
+	var out bytes.Buffer
+	offset, mi := 0, 0
+	for _, obj := range chunks {
+		text := chunkText(obj, isChat)
+		var b strings.Builder
+		for i := 0; i < len(text); {
+			pos := offset + i
+			for mi < len(matches) && matches[mi].end <= pos {
+				mi++
+			}
+			if mi < len(matches) && pos >= matches[mi].start {
+				if pos == matches[mi].start {
+					b.WriteString("[REDACTED:" + matches[mi].name + "]")
+				}
+				i = min(len(text), matches[mi].end-offset)
+				continue
+			}
+			next := len(text)
+			if mi < len(matches) {
+				next = min(next, matches[mi].start-offset)
+			}
+			b.WriteString(text[i:next])
+			i = next
+		}
+		offset += len(text)
+		setChunkText(obj, isChat, b.String())
+		line, _ := json.Marshal(obj)
+		out.Write(line)
+		if stream {
+			out.WriteByte('\n')
+		}
+	}
+	return out.Bytes()
+}
+
+// responseScanReport carries the response scan outcome back to the code
+// that logs the request.
+type responseScanReport struct {
+	Findings []string
+	Blocked  bool
+	// TooLarge is set when the response was over ResponseScanMaxBytes
+	TooLarge bool
+	// Snippet is the start of the response text, with LogResponseSnippet
+	Snippet string
+}
+
+type scanReportKey struct{}
+
+func withScanReport(r *http.Request) (*http.Request, *responseScanReport) {
+	report := &responseScanReport{}
+	return r.WithContext(context.WithValue(r.Context(), scanReportKey{}, report)), report
+}
+
+func scanReportFrom(ctx context.Context) *responseScanReport {
+	report, _ := ctx.Value(scanReportKey{}).(*responseScanReport)
+	return report
+}
+
+// apply records the scan outcome on a log entry.
+func (rep *responseScanReport) apply(entry *InspectionLog) {
+	if rep == nil {
+		return
+	}
+	entry.ResponseSnippet = rep.Snippet
+	if rep.Blocked && rep.TooLarge {
+		entry.Action = "blocked (response too large)"
+		return
+	}
+	if len(rep.Findings) == 0 {
+		return
+	}
+	entry.ResponseFindings = rep.Findings
+	if rep.Blocked {
+		entry.Action = "blocked (response leak)"
+	}
+}
+
+// scansResponse reports whether a backend response to r is scanned.
+func scansResponse(cfg Config, r *http.Request, resp *http.Response) bool {
+	return cfg.ResponseScan != "" && cfg.ResponseScan != "off" && resp.StatusCode == http.StatusOK &&
+		(r.URL.Path == "/api/chat" || r.URL.Path == "/api/generate")
+}
+
+// scanResponse checks a complete backend response for secrets and protected
+// prompt text. It returns the response to send on, with matches redacted in
+// "redact" mode, or nil when the response must be replaced by a block message.
+// An optional inspector pass catches leaks the patterns don't; since it can't
+// point at what leaked, it only blocks in "block" mode and is logged in
+// "redact" mode.
+func (p *Proxy) scanResponse(ctx context.Context, cfg Config, r *http.Request, body, data []byte, stream bool) []byte {
+	chunks, ok := responseChunks(data)
+	if !ok {
+		return data
+	}
+	isChat := r.URL.Path == "/api/chat"
+	var text strings.Builder
+	for _, obj := range chunks {
+		text.WriteString(chunkText(obj, isChat))
+	}
+
+	matches := findLeaks(cfg, text.String(), requestSystemPrompts(body))
+	findings := leakNames(matches)
+	llmLeak := false
+	if cfg.ResponseScanLLM && cfg.InspectorMode != "heuristic" && strings.TrimSpace(text.String()) != "" {
+		result, err := p.inspector.doInspect(ctx, cfg, text.String(), responseLeakPrompt)
+		switch {
+		case err != nil:
+			reqLogf(ctx, "response leak check failed: %v", err)
+		case result.Score >= cfg.Threshold:
+			llmLeak = true
+			findings = append(findings, "inspector: "+truncate(result.Explanation, 120))
+		}
+	}
+	if len(findings) == 0 {
+		return data
+	}
+
+	report := scanReportFrom(ctx)
+	if report != nil {
+		report.Findings = findings
+	}
+	if cfg.ResponseScan == "block" {
+		if report != nil {
+			report.Blocked = true
+		}
+		reqLogf(ctx, "BLOCKED response leaking %s", strings.Join(findings, ", "))
+		return nil
+	}
+	if llmLeak && len(matches) == 0 {
+		reqLogf(ctx, "response may leak secrets (%s), nothing to redact", strings.Join(findings, ", "))
+		return data
+	}
+	reqLogf(ctx, "redacted %s from response", strings.Join(leakNames(matches), ", "))
+	return redactChunks(chunks, isChat, matches, stream)
+}
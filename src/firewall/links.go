@@ -0,0 +1,273 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var reURL = regexp.MustCompile(`https?://[^\s<>"'\x60)\]]+`)
+
+// reInstructionWords spots imperative phrasing smuggled into URL query
+// strings and fragments.
+var reInstructionWords = regexp.MustCompile(`(?i)\b(ignore|disregard|instructions?|system prompt|assistant|you are now|reveal|exfiltrate)\b`)
+
+var (
+	reHTMLDrop    = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)>`)
+	reHTMLComment = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+	reHTMLTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+	reSpaces      = regexp.MustCompile(`[ \t]+`)
+	reBlankLines  = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// blockedHosts are metadata endpoints reachable by name.
+var blockedHosts = map[string]bool{
+	"metadata.google.internal": true,
+	"metadata":                 true,
+	"localhost":                true,
+}
+
+var errBlockedAddress = errors.New("address not allowed")
+
+// blockedAddr reports addresses link fetching must never connect to:
+// loopback, RFC 1918 and unique-local ranges, link-local (which includes the
+// 169.254.169.254 cloud metadata endpoint), CGNAT, multicast and unspecified.
+func blockedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsMulticast() || addr.IsUnspecified() || addr.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, p := range []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("fd00:ec2::/32"),
+	} {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// newLinkClient builds the client used to fetch links. Addresses are checked
+// after DNS resolution, at connect time, so a public name that resolves to
+// an internal address (or is rebound to one) is refused too. Environment
+// proxies are ignored since they would hide the real destination.
+func newLinkClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || blockedAddr(addr) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return checkLinkURL(req.URL)
+		},
+	}
+}
+
+// checkLinkURL rejects URLs that are never fetched, before any connection.
+func checkLinkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme %q not allowed", u.Scheme)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if blockedHosts[host] || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && blockedAddr(addr) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	return nil
+}
+
+// extractURLs returns the distinct URLs in content, in order of appearance.
+func extractURLs(content string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, u := range reURL.FindAllString(content, -1) {
+		u = strings.TrimRight(u, ".,;:!?")
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// suspiciousURL lists the reasons a URL looks like an injection vehicle,
+// whether or not it is fetched.
+func suspiciousURL(raw string) []string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return []string{"unparseable URL"}
+	}
+	var reasons []string
+	host := u.Hostname()
+	if _, err := netip.ParseAddr(host); err == nil {
+		reasons = append(reasons, "raw IP address host")
+	}
+	if u.User != nil {
+		reasons = append(reasons, "credentials or @ in authority")
+	}
+	if strings.Contains(host, "xn--") {
+		reasons = append(reasons, "punycode hostname")
+	}
+	if checkLinkURL(u) != nil {
+		reasons = append(reasons, "points at an internal address")
+	}
+	decoded, _ := url.QueryUnescape(u.RawQuery + " " + u.Fragment)
+	if reInstructionWords.MatchString(decoded) {
+		reasons = append(reasons, "instruction-like text in query or fragment: "+truncate(strings.TrimSpace(decoded), 200))
+	}
+	if len(raw) > 500 {
+		reasons = append(reasons, fmt.Sprintf("unusually long (%d chars)", len(raw)))
+	}
+	return reasons
+}
+
+// htmlToText reduces a page to its text, keeping comments, which are a
+// common place to hide instructions meant for a model.
+func htmlToText(page string) string {
+	page = reHTMLDrop.ReplaceAllString(page, " ")
+	page = reHTMLComment.ReplaceAllString(page, " $1 ")
+	page = reHTMLTag.ReplaceAllString(page, " ")
+	page = strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(page)
+	page = reSpaces.ReplaceAllString(page, " ")
+	return strings.TrimSpace(reBlankLines.ReplaceAllString(page, "\n\n"))
+}
+
+// linkFetcher fetches linked pages for inspection.
+type linkFetcher struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	client  *http.Client
+}
+
+func (lf *linkFetcher) get(timeout time.Duration) *http.Client {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.client == nil || lf.timeout != timeout {
+		lf.client, lf.timeout = newLinkClient(timeout), timeout
+	}
+	return lf.client
+}
+
+// fetch retrieves the text of one link, capped at maxBytes.
+func (lf *linkFetcher) fetch(ctx context.Context, cfg Config, raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if err := checkLinkURL(u); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "ai-context-firewall link inspector")
+	resp, err := lf.get(time.Duration(cfg.FetchTimeoutMs) * time.Millisecond).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "text/") && mediaType != "application/json" && !strings.HasSuffix(mediaType, "+xml") && mediaType != "application/xml" {
+		return "", fmt.Errorf("content type %q not inspected", mediaType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, cfg.FetchMaxBytes))
+	if err != nil {
+		return "", err
+	}
+	text := string(data)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		text = htmlToText(text)
+	}
+	return text, nil
+}
+
+// augment appends link findings to the content the inspector sees: the
+// reasons any URL looks suspicious and, with FetchLinks, the text of up to
+// FetchMaxLinks linked pages. Content without URLs is returned unchanged.
+func (lf *linkFetcher) augment(ctx context.Context, cfg Config, content string) string {
+	urls := extractURLs(content)
+	if len(urls) == 0 {
+		return content
+	}
+
+	var b strings.Builder
+	b.WriteString(content)
+	for _, u := range urls {
+		if reasons := suspiciousURL(u); len(reasons) > 0 {
+			reqLogf(ctx, "suspicious link %s: %s", truncate(u, 120), strings.Join(reasons, "; "))
+			fmt.Fprintf(&b, "\n\n[Suspicious link %s: %s]", u, strings.Join(reasons, "; "))
+		}
+	}
+
+	if cfg.FetchLinks {
+		if len(urls) > cfg.FetchMaxLinks {
+			urls = urls[:cfg.FetchMaxLinks]
+		}
+		texts := make([]string, len(urls))
+		var wg sync.WaitGroup
+		for i, u := range urls {
+			wg.Add(1)
+			go func(i int, u string) {
+				defer wg.Done()
+				text, err := lf.fetch(ctx, cfg, u)
+				if err != nil {
+					reqLogf(ctx, "not inspecting linked content of %s: %v", truncate(u, 120), err)
+					return
+				}
+				texts[i] = text
+			}(i, u)
+		}
+		wg.Wait()
+		for i, text := range texts {
+			if strings.TrimSpace(text) != "" {
+				fmt.Fprintf(&b, "\n\n[Content linked from %s]\n%s", urls[i], text)
+			}
+		}
+	}
+	return b.String()
+}
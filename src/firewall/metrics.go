@@ -0,0 +1,97 @@
+package firewall
+
+import (
+	"sync/atomic"
+)
+
+// parseMinSamples is the number of parsed inspector replies needed before
+// parse-failure rates are considered meaningful enough to warn about.
+const parseMinSamples = 20
+
+// parseCounters records which strategy parseInspectionResult succeeded with.
+// A high regex-fallback or failure rate usually means the inspector model is too small.
+var parseCounters struct {
+	Direct    atomic.Int64
+	Extracted atomic.Int64
+	Regex     atomic.Int64
+	Failed    atomic.Int64
+	// Early counts streamed replies decided before the inspector finished
+	Early atomic.Int64
+
+	// Reprompted counts follow-up calls after a failed parse, and
+	// RepromptRecovered those that then parsed
+	Reprompted        atomic.Int64
+	RepromptRecovered atomic.Int64
+
+	// LevelDisagreed counts replies whose risk_level didn't match the band
+	// of their score
+	LevelDisagreed atomic.Int64
+
+	// Truncated counts replies that ended before their JSON closed
+	Truncated atomic.Int64
+
+	warned atomic.Bool
+}
+
+type ParseMetrics struct {
+	Direct            int64   `json:"direct"`
+	Extracted         int64   `json:"extracted"`
+	RegexFallback     int64   `json:"regex_fallback"`
+	Failed            int64   `json:"failed"`
+	Early             int64   `json:"early"`
+	Total             int64   `json:"total"`
+	Reprompted        int64   `json:"reprompted"`
+	RepromptRecovered int64   `json:"reprompt_recovered"`
+	LevelDisagreed    int64   `json:"level_disagreed"`
+	Truncated         int64   `json:"truncated"`
+	DegradedPct       float64 `json:"degraded_pct"`
+}
+
+type QueueMetrics struct {
+	Limit    int   `json:"limit"`
+	Depth    int   `json:"depth"`
+	InFlight int64 `json:"in_flight"`
+	Queued   int64 `json:"queued"`
+	Rejected int64 `json:"rejected"`
+}
+
+type Metrics struct {
+	Parse    ParseMetrics   `json:"parse"`
+	Queue    QueueMetrics   `json:"queue"`
+	Requests RequestMetrics `json:"requests"`
+}
+
+func parseMetricsSnapshot() ParseMetrics {
+	m := ParseMetrics{
+		Direct:            parseCounters.Direct.Load(),
+		Extracted:         parseCounters.Extracted.Load(),
+		RegexFallback:     parseCounters.Regex.Load(),
+		Failed:            parseCounters.Failed.Load(),
+		Early:             parseCounters.Early.Load(),
+		Reprompted:        parseCounters.Reprompted.Load(),
+		RepromptRecovered: parseCounters.RepromptRecovered.Load(),
+		LevelDisagreed:    parseCounters.LevelDisagreed.Load(),
+		Truncated:         parseCounters.Truncated.Load(),
+	}
+	m.Total = m.Direct + m.Extracted + m.RegexFallback + m.Failed + m.Early
+	if m.Total > 0 {
+		m.DegradedPct = float64(m.RegexFallback+m.Failed) * 100 / float64(m.Total)
+	}
+	return m
+}
+
+// parseDegraded reports whether the share of replies that needed the regex
+// fallback or could not be parsed at all is at or above warnPct. It returns
+// true only once per crossing so callers can log a single warning, and re-arms
+// when the rate drops back below the limit.
+func parseDegraded(warnPct int) (ParseMetrics, bool) {
+	m := parseMetricsSnapshot()
+	if warnPct <= 0 || m.Total < parseMinSamples {
+		return m, false
+	}
+	if m.DegradedPct >= float64(warnPct) {
+		return m, parseCounters.warned.CompareAndSwap(false, true)
+	}
+	parseCounters.warned.Store(false)
+	return m, false
+}
@@ -0,0 +1,281 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Decision is the inspection outcome for a request passed through a
+// Middleware. Downstream handlers read it with DecisionFrom.
+type Decision struct {
+	Result *InspectionResult // nil when the request wasn't inspected or inspection failed
+	Action string           // as logged: "forwarded", "warned", "blocked", "forwarded (inspection error)", "rejected (overloaded)", ...
+	Err    error            // inspection error, if any
+}
+
+// Blocked reports whether the request was refused, as blocked or as
+// rejected under overload.
+func (d *Decision) Blocked() bool {
+	return strings.HasPrefix(d.Action, "blocked") || strings.HasPrefix(d.Action, "rejected")
+}
+
+type decisionKey struct{}
+
+// DecisionFrom returns the decision the Middleware stored in ctx.
+func DecisionFrom(ctx context.Context) (*Decision, bool) {
+	d, ok := ctx.Value(decisionKey{}).(*Decision)
+	return d, ok
+}
+
+// Middleware inspects request bodies in front of an arbitrary handler, for
+// programs that serve their own routes rather than running the Ollama proxy.
+// It applies Threshold, WarnAt and FailMode and logs to the store like the
+// proxy; quarantine, warning injection and response scanning are proxy-only.
+type Middleware struct {
+	store     *Store
+	inspector *Inspector
+
+	// Extract returns the text to inspect from a request body. The default
+	// reads prompt, system and messages from Ollama, OpenAI and Anthropic
+	// style JSON bodies.
+	Extract func(r *http.Request, body []byte) (string, error)
+
+	// OnBlock answers a blocked request. The default sends a 403 JSON error,
+	// or a 503 when the inspection queue was full and OverloadPolicy is
+	// "reject".
+	OnBlock func(w http.ResponseWriter, r *http.Request, d *Decision)
+}
+
+// NewMiddleware returns a Middleware using the store's config and inspector.
+func NewMiddleware(store *Store, inspector *Inspector) *Middleware {
+	m := &Middleware{store: store, inspector: inspector, OnBlock: writeBlocked}
+	m.Extract = func(r *http.Request, body []byte) (string, error) {
+		return extractContent(m.store.GetConfig(), body)
+	}
+	return m
+}
+
+// Wrap returns a handler that inspects each request and, unless it's blocked,
+// calls next with the Decision in the request context. The body is restored
+// so next can read it again.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestID(w, r)
+		r = withClientIP(r, m.store.GetConfig())
+		body, ok := readLimitedBody(w, r, m.store.GetConfig().MaxBodyBytes)
+		if !ok {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		content, err := m.Extract(r, body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+			return
+		}
+
+		d := m.decide(r, content)
+		if d.Blocked() {
+			m.OnBlock(w, r, d)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decisionKey{}, d)))
+	})
+}
+
+// WrapURL is Wrap in front of a reverse proxy to target.
+func (m *Middleware) WrapURL(target string) (http.Handler, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("target %q must be an absolute URL", target)
+	}
+	return m.Wrap(httputil.NewSingleHostReverseProxy(u)), nil
+}
+
+// decide inspects content and logs the outcome.
+func (m *Middleware) decide(r *http.Request, content string) *Decision {
+	start := time.Now()
+	cfg := m.store.GetConfig()
+	logEntry := InspectionLog{
+		RequestID: requestIDFrom(r.Context()),
+		Client:    clientID(r),
+		ClientIP:  clientIPFrom(r.Context()),
+		Content:   storedContent(cfg, content),
+		RiskLevel: "unknown",
+		Score:     -1,
+	}
+	d := &Decision{}
+	defer func() {
+		logEntry.Action = d.Action
+		logEntry.TotalTimeMs = time.Since(start).Milliseconds()
+		m.store.AddLog(logEntry)
+		reqLogf(r.Context(), "%s request via middleware %s (total %dms): %s",
+			strings.ToUpper(d.Action), r.URL.Path, logEntry.TotalTimeMs, truncate(storedContent(cfg, content), 80))
+	}()
+
+	if trimmed := strings.TrimSpace(content); trimmed == "" || utf8.RuneCountInString(trimmed) < cfg.MinInspectChars {
+		d.Action = "forwarded (no content)"
+		if trimmed != "" {
+			d.Action = "forwarded (below min length)"
+		}
+		logEntry.RiskLevel, logEntry.Score = "safe", 0
+		return d
+	}
+	if !sampled(cfg, content) {
+		d.Action = actionUnsampled
+		logEntry.Explanation = unsampledExplanation(cfg)
+		return d
+	}
+
+	ctx, timing := withInspectTiming(r.Context())
+	result, err := m.inspector.Inspect(withInspectMeta(ctx, inspectMeta{
+		Path:   r.URL.Path,
+		Source: sourceUser,
+	}), content)
+	logEntry.InspectorModel = cfg.inspectorLabel()
+	logEntry.QueueWaitMs, logEntry.InspectTimeMs = timing.ms()
+	if err != nil {
+		d.Err = err
+		reason := "inspection error"
+		failClosed := cfg.FailMode == "closed"
+		var notFound *ModelNotFoundError
+		var unexpected *UnexpectedReplyError
+		switch {
+		case errors.Is(err, ErrCircuitOpen):
+			reason = "circuit open"
+		case errors.As(err, &notFound):
+			reason = "model not found"
+		case errors.As(err, &unexpected):
+			reason = "unexpected inspector reply"
+		case errors.Is(err, ErrOverloaded):
+			// Overload has its own policy, independent of fail_mode
+			reason = "overloaded"
+			failClosed = cfg.OverloadPolicy == "block"
+		}
+		d.Action = "forwarded (" + reason + ")"
+		if failClosed {
+			d.Action = "blocked (" + reason + ")"
+		}
+		if reason == "overloaded" && cfg.OverloadPolicy == "reject" {
+			d.Action = "rejected (overloaded)"
+		}
+		logEntry.Explanation = fmt.Sprintf("inspection failed: %v", err)
+		var parseErr *ParseError
+		if cfg.DebugInspector && errors.As(err, &parseErr) {
+			logEntry.RawResponse = storedRaw(cfg, parseErr.Raw)
+		}
+		return d
+	}
+
+	d.Result = result
+	d.Action = "forwarded"
+	switch {
+	case result.Score >= cfg.Threshold:
+		d.Action = "blocked"
+	case cfg.WarnAt > 0 && result.Score >= cfg.WarnAt:
+		d.Action = "warned"
+	}
+	logEntry.RiskLevel = result.RiskLevel
+	logEntry.RawRiskLevel = result.RawRiskLevel
+	logEntry.Score = result.Score
+	logEntry.Explanation = result.Explanation
+	logEntry.InspectPromptTokens = result.PromptTokens
+	logEntry.InspectEvalTokens = result.EvalTokens
+	logEntry.MatchedRules = result.MatchedRules
+	logEntry.Chain = result.Chain
+	logEntry.Confidence = result.Confidence
+	logEntry.Spans = storedSpans(cfg, result.Spans)
+	logEntry.Categories = result.Categories
+	return d
+}
+
+// writeBlocked is the default Middleware.OnBlock.
+func writeBlocked(w http.ResponseWriter, r *http.Request, d *Decision) {
+	if strings.HasPrefix(d.Action, "rejected") {
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusServiceUnavailable, errOverloaded, "AI Context Firewall is overloaded, try again shortly")
+		return
+	}
+	msg := "request blocked by AI Context Firewall"
+	if d.Result != nil {
+		msg = fmt.Sprintf("%s: risk score %d/100 (%s). %s", msg, d.Result.Score, d.Result.RiskLevel, d.Result.Explanation)
+	}
+	writeJSONError(w, http.StatusForbidden, errBlocked, msg)
+}
+
+// extractContent collects the text of a JSON request body: prompt, system,
+// and the messages of the inspected roles, whose content may be a string or
+// a list of {"type": "text", "text": ...} parts.
+func extractContent(cfg Config, body []byte) (string, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", nil
+	}
+	var req struct {
+		Prompt   string          `json:"prompt"`
+		System   json.RawMessage `json:"system"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", errors.New("invalid JSON")
+	}
+
+	var parts []string
+	if cfg.InspectsRole("system") {
+		if s := textOf(req.System); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if req.Prompt != "" {
+		parts = append(parts, req.Prompt)
+	}
+	for _, msg := range req.Messages {
+		if !cfg.InspectsRole(msg.Role) {
+			continue
+		}
+		if s := textOf(msg.Content); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return joinInspected(cfg, parts), nil
+}
+
+// textOf reads a JSON string or a list of text parts.
+func textOf(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &blocks) != nil {
+		return ""
+	}
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == "text" && b.Text != "" {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,74 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ModelNotFoundError is returned when a host answers that a model isn't
+// pulled, which almost always means a typo in a model name or a missing
+// "ollama pull" rather than a transient failure.
+type ModelNotFoundError struct {
+	Role  string // "inspector" or "backend"
+	Model string
+	URL   string
+}
+
+func (e *ModelNotFoundError) Error() string {
+	return fmt.Sprintf("%s model %q not found at %s: pull it with \"ollama pull %s\" on that host or correct the model name",
+		e.Role, e.Model, e.URL, e.Model)
+}
+
+// isModelNotFound recognizes Ollama's 404 "model ... not found" reply and the
+// OpenAI-style "model ... does not exist".
+func isModelNotFound(status int, body []byte) bool {
+	if status != http.StatusNotFound {
+		return false
+	}
+	msg := strings.ToLower(string(body))
+	return strings.Contains(msg, "model") && (strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist"))
+}
+
+// requestModel returns the "model" field of a JSON request body.
+func requestModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Model
+}
+
+// modelListed reports whether name is in a model list as returned by
+// fetchModelList. Ollama lists "llama3:latest" for "llama3".
+func modelListed(models []json.RawMessage, name string) bool {
+	for _, raw := range models {
+		var m struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		}
+		if json.Unmarshal(raw, &m) != nil {
+			continue
+		}
+		for _, n := range []string{m.Name, m.Model} {
+			if n != "" && (n == name || n == name+":latest") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckModel makes sure the inspector model is available at startup, so a
+// misconfigured name or an unreachable host is reported before the first
+// request rather than as a stream of inspection errors. With AutoPullModel a
+// missing model is pulled in the background. The inspector is then re-probed
+// every inspectorProbeInterval; see Health.
+func (ins *Inspector) CheckModel(cfg Config) {
+	if canAutoPull(cfg) {
+		ins.puller.watch(cfg)
+	}
+	ins.health.check(cfg)
+	ins.health.monitor(ins.store)
+}
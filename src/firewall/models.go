@@ -0,0 +1,180 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// modelListTTL is how long a fetched model list is served without
+	// refetching. Older lists are still served while a refresh runs.
+	modelListTTL = 30 * time.Second
+	// modelListTimeout bounds one fetch of a model list.
+	modelListTimeout = 5 * time.Second
+)
+
+type modelListKey struct {
+	url    string
+	openAI bool
+}
+
+type modelListEntry struct {
+	models     []json.RawMessage
+	fetchedAt  time.Time
+	failed     bool
+	refreshing bool
+}
+
+// modelCache keeps the model list per host, so the config page doesn't wait
+// on /api/tags every time it loads. Only the configured inspector and backend
+// are cached.
+type modelCache struct {
+	mu      sync.Mutex
+	entries map[modelListKey]*modelListEntry
+}
+
+// get returns the model list for key. A fresh cached list is returned as is;
+// a stale one is returned immediately while a background refresh runs. With
+// no cached list, or when force is set, it fetches synchronously. If that
+// fetch fails, the last known list is returned with stale set.
+func (c *modelCache) get(key modelListKey, apiKey string, force bool) (models []json.RawMessage, stale bool, err error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[modelListKey]*modelListEntry{}
+	}
+	e := c.entries[key]
+	if e != nil && !force {
+		if time.Since(e.fetchedAt) >= modelListTTL && !e.refreshing {
+			e.refreshing = true
+			go c.refresh(key, apiKey)
+		}
+		models, stale = e.models, e.failed
+		c.mu.Unlock()
+		return models, stale, nil
+	}
+	c.mu.Unlock()
+
+	models, err = fetchModelList(key, apiKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if e := c.entries[key]; e != nil {
+			e.failed = true
+			return e.models, true, err
+		}
+		return nil, false, err
+	}
+	c.entries[key] = &modelListEntry{models: models, fetchedAt: time.Now()}
+	return models, false, nil
+}
+
+func (c *modelCache) refresh(key modelListKey, apiKey string) {
+	models, err := fetchModelList(key, apiKey)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	e.refreshing = false
+	if err != nil {
+		e.failed = true
+		return
+	}
+	e.models, e.fetchedAt, e.failed = models, time.Now(), false
+}
+
+// fetchModelList lists the models on an Ollama host, or on an OpenAI-compatible
+// one reshaped into Ollama's [{"name": ...}] form.
+func fetchModelList(key modelListKey, apiKey string) ([]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), modelListTimeout)
+	defer cancel()
+
+	path := "/api/tags"
+	if key.openAI {
+		path = "/v1/models"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key.url+path, nil)
+	if err != nil {
+		return nil, errors.New("invalid URL")
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.New("cannot reach Ollama at " + key.url)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected response from Ollama")
+	}
+
+	if key.openAI {
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		json.Unmarshal(body, &list)
+		models := make([]json.RawMessage, 0, len(list.Data))
+		for _, m := range list.Data {
+			name, _ := json.Marshal(map[string]string{"name": m.ID})
+			models = append(models, name)
+		}
+		return models, nil
+	}
+
+	var tags struct {
+		Models []json.RawMessage `json:"models"`
+	}
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return nil, errors.New("unexpected response from Ollama")
+	}
+	return tags.Models, nil
+}
+
+// defaultModelCache remembers which model a backend runs requests without one
+// on, for modelListTTL, failures included, so a backend that can't be asked
+// doesn't slow down every such request.
+type defaultModelCache struct {
+	mu        sync.Mutex
+	url       string
+	model     string
+	fetchedAt time.Time
+}
+
+// resolveModel names the model a request without one will run on, for logs
+// and usage: DefaultBackendModel, or else the backend's only installed model.
+// With several installed, or none reachable, it returns "".
+func (p *Proxy) resolveModel(cfg Config) string {
+	if cfg.DefaultBackendModel != "" {
+		return cfg.DefaultBackendModel
+	}
+	c := &p.defaultModel
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.url == cfg.BackendURL && time.Since(c.fetchedAt) < modelListTTL {
+		return c.model
+	}
+	c.url, c.model, c.fetchedAt = cfg.BackendURL, "", time.Now()
+	models, err := fetchModelList(modelListKey{url: cfg.BackendURL}, "")
+	if err != nil || len(models) != 1 {
+		return ""
+	}
+	var m struct {
+		Name  string `json:"name"`
+		Model string `json:"model"`
+	}
+	json.Unmarshal(models[0], &m)
+	c.model = m.Name
+	if c.model == "" {
+		c.model = m.Model
+	}
+	return c.model
+}
@@ -0,0 +1,61 @@
+package firewall
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// homoglyphs maps common Cyrillic and Greek lookalikes to the ASCII letters
+// they imitate, e.g. "іgnоrе" written with Cyrillic і/о/е.
+var homoglyphs = map[rune]rune{
+	// Cyrillic lowercase
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd',
+	'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l',
+	// Cyrillic uppercase
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'І': 'I', 'Ј': 'J', 'Ѕ': 'S',
+	// Greek
+	'α': 'a', 'ο': 'o', 'ρ': 'p', 'ν': 'v', 'ι': 'i', 'κ': 'k', 'τ': 't', 'υ': 'u',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
+
+// isInvisible reports characters that render as nothing but can split or hide
+// words: zero-width spaces/joiners, bidi overrides, soft hyphens, Unicode tag
+// characters (used for "ASCII smuggling"), and other control characters.
+func isInvisible(r rune) bool {
+	switch {
+	case r == '\n' || r == '\r' || r == '\t':
+		return false
+	case r >= 0x200B && r <= 0x200F, // zero-width space/joiners, LRM/RLM
+		r >= 0x202A && r <= 0x202E, // bidi embeddings and overrides
+		r >= 0x2060 && r <= 0x2064, // word joiner, invisible operators
+		r >= 0x2066 && r <= 0x2069, // bidi isolates
+		r >= 0xE0000 && r <= 0xE007F, // tag characters
+		r == 0xFEFF, r == 0x00AD, r == 0x180E, r == 0x034F:
+		return true
+	}
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
+
+// normalizeForInspection canonicalizes content before it reaches the inspector
+// model: NFKC normalization (fullwidth and styled letters become plain ones),
+// removal of invisible characters, and optionally homoglyph folding to ASCII.
+// Homoglyph folding rewrites legitimate Cyrillic/Greek text, so it's separate.
+func normalizeForInspection(s string, foldHomoglyphs bool) string {
+	s = norm.NFKC.String(s)
+	return strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		if foldHomoglyphs {
+			if a, ok := homoglyphs[r]; ok {
+				return a
+			}
+		}
+		return r
+	}, s)
+}
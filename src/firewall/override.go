@@ -0,0 +1,65 @@
+package firewall
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	inspectOverrideHeader = "X-Firewall-Inspect"
+	overrideTokenHeader   = "X-Firewall-Token"
+
+	overrideSkip  = "skip"
+	overrideForce = "force"
+)
+
+type inspectOverrideKey struct{}
+
+// withInspectOverride reads the X-Firewall-Inspect header. It is honored only
+// when OverrideToken is configured and X-Firewall-Token matches it; otherwise
+// it's ignored. Both headers are removed so they never reach the backend.
+func (p *Proxy) withInspectOverride(r *http.Request) *http.Request {
+	mode := r.Header.Get(inspectOverrideHeader)
+	token := r.Header.Get(overrideTokenHeader)
+	r.Header.Del(inspectOverrideHeader)
+	r.Header.Del(overrideTokenHeader)
+	if mode == "" {
+		return r
+	}
+
+	expected := p.store.GetConfig().OverrideToken
+	if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		reqLogf(r.Context(), "ignoring %s: %s without a valid %s", inspectOverrideHeader, mode, overrideTokenHeader)
+		return r
+	}
+	if mode != overrideSkip && mode != overrideForce {
+		reqLogf(r.Context(), "ignoring %s: unknown mode %q", inspectOverrideHeader, mode)
+		return r
+	}
+	reqLogf(r.Context(), "inspection override %q applied to %s %s", mode, r.Method, r.URL.Path)
+	return r.WithContext(context.WithValue(r.Context(), inspectOverrideKey{}, mode))
+}
+
+func inspectOverrideFrom(ctx context.Context) string {
+	mode, _ := ctx.Value(inspectOverrideKey{}).(string)
+	return mode
+}
+
+// handleForced inspects a request to an endpoint that is normally passed
+// through. Without knowing its schema, the whole body is inspected as text.
+func (p *Proxy) handleForced(w http.ResponseWriter, r *http.Request) {
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Model  string `json:"model"`
+		Stream *bool  `json:"stream"`
+	}
+	json.Unmarshal(body, &req)
+
+	p.inspectAndForward(w, r, body, string(body), "", req.Model, false, isStreaming(req.Stream), false, false)
+}
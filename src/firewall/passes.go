@@ -0,0 +1,119 @@
+package firewall
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// samplePassTemperature is used for multi-pass inspection when the configured
+// temperature is 0, since identical passes would add cost but no information.
+const samplePassTemperature = 0.7
+
+// inspectPasses runs SamplePasses inspections of the same content in parallel
+// and combines their scores with SampleAggregation (mean, median or max).
+// Failed passes are left out; it only fails if every pass does.
+func (ins *Inspector) inspectPasses(ctx context.Context, cfg Config, content, systemPrompt string) (*InspectionResult, error) {
+	passCfg := cfg
+	if passCfg.InspectorTemperature == 0 {
+		passCfg.InspectorTemperature = samplePassTemperature
+	}
+
+	results := make([]*InspectionResult, cfg.SamplePasses)
+	errs := make([]error, cfg.SamplePasses)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := passCfg
+			if c.InspectorSeed != 0 {
+				// A fixed seed would make every pass identical
+				c.InspectorSeed += i
+			}
+			results[i], errs[i] = ins.doInspect(ctx, c, content, systemPrompt)
+		}(i)
+	}
+	wg.Wait()
+
+	var ok []*InspectionResult
+	var firstErr error
+	for i, r := range results {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		ok = append(ok, r)
+	}
+	if len(ok) == 0 {
+		return nil, firstErr
+	}
+
+	method := cfg.SampleAggregation
+	if method == "" {
+		method = "median"
+	}
+	scores := make([]int, len(ok))
+	combined := &InspectionResult{Aggregation: method}
+	for i, r := range ok {
+		scores[i] = r.Score
+		combined.PromptTokens += r.PromptTokens
+		combined.EvalTokens += r.EvalTokens
+	}
+	combined.PassScores = scores
+	combined.Score = aggregateScores(scores, method)
+	combined.RiskLevel = riskLevelFor(cfg, combined.Score)
+
+	// Explain with the pass that came closest to the combined score
+	closest := ok[0]
+	for _, r := range ok[1:] {
+		if abs(r.Score-combined.Score) < abs(closest.Score-combined.Score) {
+			closest = r
+		}
+	}
+	combined.Explanation = closest.Explanation
+	for _, r := range ok {
+		combined.Categories = append(combined.Categories, r.Categories...)
+		combined.Spans = append(combined.Spans, r.Spans...)
+	}
+	combined.Categories = normalizeCategories(combined.Categories)
+	combined.Spans = normalizeSpans(combined.Spans)
+	combined.Raw = closest.Raw
+	combined.Confidence = closest.Confidence
+	return combined, nil
+}
+
+func aggregateScores(scores []int, method string) int {
+	switch method {
+	case "max":
+		max := scores[0]
+		for _, s := range scores[1:] {
+			if s > max {
+				max = s
+			}
+		}
+		return max
+	case "mean":
+		sum := 0
+		for _, s := range scores {
+			sum += s
+		}
+		return (sum + len(scores)/2) / len(scores)
+	}
+	sorted := append([]int(nil), scores...)
+	sort.Ints(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2] + 1) / 2
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,80 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Request sources that PromptRoutes can map to a prompt, besides endpoint
+// paths such as "/api/chat".
+const (
+	sourceUser     = "user"     // /api/chat or /v1/messages without tool results
+	sourceTool     = "tool"     // /api/chat or /v1/messages carrying tool results
+	sourceGenerate = "generate" // /api/generate
+)
+
+// inspectMeta describes the request being inspected, for prompt selection
+// and prompt template variables.
+type inspectMeta struct {
+	Path         string
+	Source       string
+	BackendModel string
+	Prompt       string // chosen by a content route, overrides the rest
+}
+
+type inspectMetaKey struct{}
+
+func withInspectMeta(ctx context.Context, meta inspectMeta) context.Context {
+	return context.WithValue(ctx, inspectMetaKey{}, meta)
+}
+
+func inspectMetaFrom(ctx context.Context) inspectMeta {
+	meta, _ := ctx.Value(inspectMetaKey{}).(inspectMeta)
+	return meta
+}
+
+// requestSource classifies a proxied request for PromptRoutes. Endpoints
+// inspected only through an override have no source, just their path.
+func requestSource(path string, fromTool bool) string {
+	switch {
+	case fromTool:
+		return sourceTool
+	case path == "/api/chat" || path == anthropicMessagesPath:
+		return sourceUser
+	case path == "/api/generate":
+		return sourceGenerate
+	}
+	return ""
+}
+
+// routedPrompt picks the prompt name for a request: a matching content route,
+// then tool content, since it is the riskier signal, then the endpoint path,
+// then the source, and finally ActivePrompt.
+func routedPrompt(cfg Config, meta inspectMeta) string {
+	if meta.Prompt != "" {
+		return meta.Prompt
+	}
+	keys := []string{meta.Path, meta.Source}
+	if meta.Source == sourceTool {
+		keys = []string{sourceTool, meta.Path}
+	}
+	for _, key := range keys {
+		if name, ok := cfg.PromptRoutes[key]; ok && key != "" {
+			return name
+		}
+	}
+	return cfg.ActivePrompt
+}
+
+func validatePromptRoutes(routes map[string]string) error {
+	for key, name := range routes {
+		if key != sourceUser && key != sourceTool && key != sourceGenerate && !strings.HasPrefix(key, "/") {
+			return fmt.Errorf("%q must be user, tool, generate or an endpoint path", key)
+		}
+		if _, ok := presetPrompts[name]; !ok && name != "custom" {
+			return fmt.Errorf("%s: unknown prompt %q", key, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// PromptVars are the values a prompt can interpolate with text/template,
+// e.g. "Today is {{.Date}}. The assistant runs {{.BackendModel}}."
+type PromptVars struct {
+	Date         string
+	Model        string
+	BackendModel string
+	Threshold    int
+	Language     string // ExplanationLanguage
+}
+
+// promptTemplates caches parsed prompts by their text.
+var promptTemplates sync.Map
+
+func parsePromptTemplate(text string) (*template.Template, error) {
+	if t, ok := promptTemplates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	promptTemplates.Store(text, t)
+	return t, nil
+}
+
+// renderPrompt fills in a prompt's template variables. Prompts without
+// template syntax are returned unchanged. A template that fails, which
+// validatePromptTemplate should have caught at save time, is used as-is
+// rather than breaking inspection.
+func renderPrompt(ctx context.Context, cfg Config, text string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	vars := PromptVars{
+		Date:         time.Now().Format("2006-01-02"),
+		Model:        cfg.InspectorModel,
+		BackendModel: inspectMetaFrom(ctx).BackendModel,
+		Threshold:    cfg.Threshold,
+		Language:     cfg.explanationLanguage(),
+	}
+	out, err := executePrompt(text, vars)
+	if err != nil {
+		reqLogf(ctx, "prompt template error, using prompt unrendered: %v", err)
+		return text
+	}
+	return out
+}
+
+func executePrompt(text string, vars PromptVars) (string, error) {
+	t, err := parsePromptTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validatePromptTemplate parses and trial-renders a prompt so syntax errors
+// and unknown fields are reported when it is saved.
+func validatePromptTemplate(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+	if _, err := executePrompt(text, PromptVars{Date: "2006-01-02", Model: "model", BackendModel: "model", Threshold: 70, Language: defaultExplanationLanguage}); err != nil {
+		return fmt.Errorf("prompt template: %w", err)
+	}
+	return nil
+}
+
+// samplePromptBackendModel stands in for the requested model when a prompt is
+// previewed outside a request.
+const samplePromptBackendModel = "example-model"
+
+// PromptPreview is a prompt as the inspector would receive it, for
+// GET /api/prompts.
+type PromptPreview struct {
+	Name      string `json:"name"`
+	Template  string `json:"template"`
+	Text      string `json:"text"`
+	Error     string `json:"error,omitempty"`
+	MaxTokens int    `json:"max_tokens"`
+	Active    bool   `json:"active"`
+}
+
+// PreviewPrompt renders the named prompt with today's date, the configured
+// inspector model and threshold, and backendModel as the requested model.
+// It reports false for a prompt that doesn't exist, including "custom" when
+// no custom prompt is set.
+func (ins *Inspector) PreviewPrompt(name, backendModel string) (PromptPreview, bool) {
+	cfg := ins.store.GetConfig()
+	if _, ok := presetPrompts[name]; !ok && (name != "custom" || cfg.CustomPrompt == "") {
+		return PromptPreview{}, false
+	}
+	if backendModel == "" {
+		backendModel = samplePromptBackendModel
+	}
+	preview := PromptPreview{
+		Name:      name,
+		Template:  ins.promptText(name),
+		MaxTokens: cfg.promptMaxTokens(name),
+		Active:    name == cfg.ActivePrompt,
+	}
+	preview.Text = preview.Template
+	if strings.Contains(preview.Template, "{{") {
+		text, err := executePrompt(preview.Template, PromptVars{
+			Date:         time.Now().Format("2006-01-02"),
+			Model:        cfg.InspectorModel,
+			BackendModel: backendModel,
+			Threshold:    cfg.Threshold,
+			Language:     cfg.explanationLanguage(),
+		})
+		if err != nil {
+			// The inspector sends such a prompt unrendered, see renderPrompt
+			preview.Error = err.Error()
+		} else {
+			preview.Text = text
+		}
+	}
+	return preview, true
+}
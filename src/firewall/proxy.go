@@ -0,0 +1,953 @@
+package firewall
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Proxy struct {
+	store     *Store
+	inspector *Inspector
+	client    *pooledClient
+	links     *linkFetcher
+	defaultModel defaultModelCache
+}
+
+func NewProxy(store *Store, inspector *Inspector) *Proxy {
+	return &Proxy{
+		store:     store,
+		inspector: inspector,
+		client:    &pooledClient{},
+		links:     &linkFetcher{},
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+	r = withClientIP(r, p.store.GetConfig())
+	r, span := startRequestSpan(r)
+	defer span.End()
+	if !p.checkEndpoint(w, r) {
+		span.SetStatus(codes.Error, "endpoint denied")
+		return
+	}
+	r = p.withInspectOverride(r)
+
+	switch r.URL.Path {
+	case "/api/chat":
+		p.handleChat(w, r)
+	case "/api/generate":
+		p.handleGenerate(w, r)
+	case anthropicMessagesPath:
+		p.handleMessages(w, r)
+	default:
+		if inspectOverrideFrom(r.Context()) == overrideForce {
+			p.handleForced(w, r)
+			return
+		}
+		// Pass through all other requests (e.g. /api/tags, /api/show)
+		if !p.checkPassthrough(w, r) {
+			span.SetStatus(codes.Error, "endpoint denied")
+			return
+		}
+		_, _ = p.forward(w, r, nil, nil)
+	}
+}
+
+func (p *Proxy) handleChat(w http.ResponseWriter, r *http.Request) {
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Model    string `json:"model"`
+		Stream   *bool  `json:"stream"`
+		Messages []struct {
+			Role     string   `json:"role"`
+			Content  string   `json:"content"`
+			Images   []string `json:"images"`
+			ToolName string   `json:"tool_name"`
+			Name     string   `json:"name"`
+		} `json:"messages"`
+		Tools []toolDefinition `json:"tools"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON")
+		return
+	}
+
+	// Extract message content of the inspected roles. System messages can be
+	// inspected on their own, against a stricter threshold. Tool definitions
+	// are always inspected, ahead of the conversation. Results of trusted
+	// tools are left out
+	cfg := p.store.GetConfig()
+	var parts, systemParts []string
+	if tools := toolsContent(req.Tools); tools != "" {
+		parts = append(parts, tools)
+	}
+	fromTool := false
+	hasImages := false
+	var toolResults []ToolResult
+	for _, msg := range req.Messages {
+		if len(msg.Images) > 0 {
+			hasImages = true
+		}
+		if msg.Role == "tool" {
+			tool := ToolResult{Name: cmp.Or(msg.ToolName, msg.Name)}
+			tool.Trusted = cfg.trustsTool(tool.Name)
+			toolResults = append(toolResults, tool)
+			if tool.Trusted {
+				continue
+			}
+		}
+		if !cfg.InspectsRole(msg.Role) {
+			continue
+		}
+		if msg.Role == "system" && cfg.InspectSystemSeparately {
+			systemParts = append(systemParts, msg.Content)
+			continue
+		}
+		parts = append(parts, msg.Content)
+		if msg.Role == "tool" {
+			fromTool = true
+		}
+	}
+	content := joinInspected(cfg, parts)
+	var system string
+	if len(systemParts) > 0 {
+		system = joinInspected(cfg, systemParts)
+	}
+
+	if toolResults != nil {
+		r = withToolResults(r, toolResults)
+	}
+	p.inspectAndForward(w, r, body, content, system, req.Model, fromTool, isStreaming(req.Stream), hasImages, len(req.Tools) > 0)
+}
+
+func (p *Proxy) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	body, ok := p.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Model  string `json:"model"`
+		Stream *bool  `json:"stream"`
+		Prompt string `json:"prompt"`
+		System string   `json:"system"`
+		Images []string `json:"images"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON")
+		return
+	}
+
+	var parts []string
+	if req.System != "" {
+		parts = append(parts, req.System)
+	}
+	content := joinInspected(p.store.GetConfig(), append(parts, req.Prompt))
+
+	p.inspectAndForward(w, r, body, content, "", req.Model, false, isStreaming(req.Stream), len(req.Images) > 0, false)
+}
+
+// readBody reads the request body, capped at MaxBodyBytes. On failure it
+// writes an Ollama-style JSON error (413 if the body is too large) and
+// returns false.
+func (p *Proxy) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	return readLimitedBody(w, r, p.store.GetConfig().MaxBodyBytes)
+}
+
+// readLimitedBody reads a request body of at most limit bytes (no limit when
+// zero), answering 413 or 400 itself when it can't.
+func readLimitedBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			reqLogf(r.Context(), "rejected request body over %d bytes", tooLarge.Limit)
+			writeJSONError(w, http.StatusRequestEntityTooLarge, errRequestTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+			return nil, false
+		}
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "failed to read request body")
+		return nil, false
+	}
+	return body, true
+}
+
+// Error codes of JSON error responses. They are stable, so clients can tell
+// a malformed request from a backend outage without parsing the message.
+const (
+	errInvalidRequest     = "invalid_request"
+	errMethodNotAllowed   = "method_not_allowed"
+	errNotFound           = "not_found"
+	errConflict           = "conflict"
+	errInternal           = "internal_error"
+	errRequestTooLarge    = "request_too_large"
+	errEndpointForbidden  = "endpoint_forbidden"
+	errBlocked            = "blocked"
+	errOverloaded         = "overloaded"
+	errBackendUnavailable = "backend_unavailable"
+	errModelNotFound      = "model_not_found"
+	errInspectionTimeout  = "inspection_timeout"
+	errBackendTimeout     = "backend_timeout"
+)
+
+// writeJSONError sends an error as {"code": ..., "message": ...}. The message
+// is repeated as "error", the field Ollama clients read.
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": msg, "error": msg})
+}
+
+// isStreaming reports whether a request wants an NDJSON stream. Like Ollama,
+// a missing "stream" field means streaming.
+func isStreaming(stream *bool) bool {
+	return stream == nil || *stream
+}
+
+// inspectAndForward inspects content and, depending on the verdict, forwards
+// the request or answers it. A non-empty system is a system prompt inspected
+// separately against SystemThreshold; the worse verdict decides.
+func (p *Proxy) inspectAndForward(w http.ResponseWriter, r *http.Request, body []byte, content, system string, model string, fromTool, stream, hasImages, hasTools bool) {
+	totalStart := time.Now()
+	cfg := p.store.GetConfig()
+	if model == "" {
+		model = p.resolveModel(cfg)
+	}
+	// The log and the allowlist see the whole inspected text
+	logged := content
+	if system != "" {
+		logged = strings.TrimSpace(system + inspectSeparator + content)
+	}
+	r, scanReport := withScanReport(r)
+	r, stopTimeout := withRequestTimeout(r, cfg)
+	defer stopTimeout()
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.String("firewall.backend_model", model), attribute.Bool("firewall.from_tool", fromTool), attribute.Bool("firewall.has_tools", hasTools))
+
+	if inspectOverrideFrom(r.Context()) == overrideSkip {
+		p.forwardUninspected(w, r, body, totalStart, InspectionLog{
+			Content:      storedContent(cfg, logged),
+			RiskLevel:    "unknown",
+			Score:        -1,
+			Explanation:  "inspection skipped by X-Firewall-Inspect override",
+			Action:       "forwarded (override skip)",
+			BackendModel: model,
+			FromTool:     fromTool,
+			HasTools:     hasTools,
+		})
+		return
+	}
+
+	// Empty or very short text isn't worth an inspector call, and small models
+	// tend to invent a score for it. Images can't be judged from the text
+	// alone, so requests carrying them are always inspected.
+	trimmed := strings.TrimSpace(content)
+	inspectMain := hasImages || (trimmed != "" && utf8.RuneCountInString(trimmed) >= cfg.MinInspectChars)
+	if !inspectMain && system == "" {
+		action := "forwarded (no content)"
+		switch {
+		case trimmed != "":
+			action = "forwarded (below min length)"
+		case onlyTrustedTools(toolResultsFrom(r.Context())):
+			action = "forwarded (trusted tool)"
+		}
+		p.forwardUninspected(w, r, body, totalStart, InspectionLog{
+			Content:      storedContent(cfg, logged),
+			RiskLevel:    "safe",
+			Score:        0,
+			Action:       action,
+			BackendModel: model,
+			FromTool:     fromTool,
+			HasTools:     hasTools,
+		})
+		return
+	}
+
+	// Content reviewed as a false positive skips inspection. Every hit is
+	// logged so a learned entry can't quietly wave through real attacks.
+	signature := contentSignature(logged)
+	if inspectOverrideFrom(r.Context()) != overrideForce {
+		if entry, ok := p.store.matchLearned(signature); ok {
+			reqLogf(r.Context(), "learned allowlist hit: signature %s (from log #%d, %d hits)", entry.Signature[:12], entry.LogID, entry.Hits)
+			p.forwardUninspected(w, r, body, totalStart, InspectionLog{
+				Content:      storedContent(cfg, logged),
+				RiskLevel:    "unknown",
+				Score:        -1,
+				Explanation:  "matched learned allowlist entry " + entry.Signature[:12],
+				Action:       "allowlisted (learned)",
+				BackendModel: model,
+				FromTool:     fromTool,
+				HasTools:     hasTools,
+				Signature:    signature,
+			})
+			return
+		}
+	}
+
+	// Content routes send recognizable content, such as JSON from an internal
+	// service, past the inspector or to a better suited prompt. A forced
+	// override ignores skip routes
+	route, routeLabel, routed := matchContentRoute(cfg, logged)
+	if routed && route.Action == contentRouteSkip && inspectOverrideFrom(r.Context()) == overrideForce {
+		route, routeLabel, routed = ContentRoute{}, "", false
+	}
+	if routed {
+		reqLogf(r.Context(), "content route %s matched", routeLabel)
+	}
+	if routed && route.Action == contentRouteSkip {
+		p.forwardUninspected(w, r, body, totalStart, InspectionLog{
+			Content:      storedContent(cfg, logged),
+			RiskLevel:    "unknown",
+			Score:        -1,
+			Explanation:  "inspection skipped by content route " + routeLabel,
+			Action:       "forwarded (content route)",
+			BackendModel: model,
+			FromTool:     fromTool,
+			HasTools:     hasTools,
+			Signature:    signature,
+			ContentRoute: routeLabel,
+		})
+		return
+	}
+
+	// Under sampling, requests not selected skip the inspector; a forced
+	// override is always inspected
+	if inspectOverrideFrom(r.Context()) != overrideForce && !sampled(cfg, logged) {
+		p.forwardUninspected(w, r, body, totalStart, InspectionLog{
+			Content:      storedContent(cfg, logged),
+			RiskLevel:    "unknown",
+			Score:        -1,
+			Explanation:  unsampledExplanation(cfg),
+			Action:       actionUnsampled,
+			BackendModel: model,
+			FromTool:     fromTool,
+			HasTools:     hasTools,
+			Signature:    signature,
+		})
+		return
+	}
+
+	// In speculative mode the backend call runs alongside inspection and its
+	// response is only released if the request isn't blocked
+	var spec *speculation
+	if cfg.Speculative {
+		spec = p.startSpeculation(r, body, cfg.SpeculativeMaxBytes)
+		defer spec.discard()
+	}
+
+	inspectStart := time.Now()
+	inspected := content
+	if cfg.InspectLinks || cfg.FetchLinks {
+		inspected = p.links.augment(r.Context(), cfg, content)
+	}
+	inspectCtx, timing := withInspectTiming(r.Context())
+	meta := inspectMeta{
+		Path:         r.URL.Path,
+		Source:       requestSource(r.URL.Path, fromTool),
+		BackendModel: model,
+	}
+	if routed {
+		meta.Prompt = route.Action
+	}
+	waitSystem := p.inspectSystem(inspectCtx, cfg, system, meta)
+	var result *InspectionResult
+	var err error
+	if inspectMain {
+		result, err = p.inspector.Inspect(withInspectMeta(inspectCtx, meta), inspected)
+	} else {
+		result = &InspectionResult{RiskLevel: "safe", Explanation: "Only the system prompt was inspected."}
+	}
+	sysVerdict := waitSystem()
+	if err == nil && sysVerdict != nil && sysVerdict.err != nil {
+		err = sysVerdict.err
+	}
+	// inspectMs is the inspector calls themselves, queueMs the time they
+	// waited for a slot
+	queueMs, inspectMs := timing.ms()
+
+	if err != nil {
+		reason := "inspection error"
+		failClosed := cfg.FailMode == "closed"
+		var notFound *ModelNotFoundError
+		var unexpected *UnexpectedReplyError
+		switch {
+		case timedOut(r.Context()):
+			reason = "request timeout"
+		case r.Context().Err() != nil:
+			reason = "client disconnected"
+		case errors.Is(err, ErrCircuitOpen):
+			reason = "circuit open"
+		case errors.As(err, &notFound):
+			reason = "model not found"
+		case errors.As(err, &unexpected):
+			reason = "unexpected inspector reply"
+		case errors.Is(err, ErrOverloaded):
+			// Overload has its own policy, independent of fail_mode
+			reason = "overloaded"
+			failClosed = cfg.OverloadPolicy == "block"
+		}
+		action := "forwarded (" + reason + ")"
+		if failClosed {
+			action = "blocked (" + reason + ")"
+		}
+		if reason == "overloaded" && cfg.OverloadPolicy == "reject" {
+			action = "rejected (overloaded)"
+		}
+		// With the time used up there is nothing left to forward with
+		if reason == "request timeout" {
+			action = "timed out (inspection)"
+		}
+		if reason == "client disconnected" {
+			action = actionDropped
+		}
+		reqLogf(r.Context(), "%s (%dms): %v", reason, time.Since(inspectStart).Milliseconds(), err)
+		logEntry := InspectionLog{
+			RequestID:      requestIDFrom(r.Context()),
+			Client:         clientID(r),
+			ClientIP:       clientIPFrom(r.Context()),
+			Content:        storedContent(cfg, logged),
+			RiskLevel:      "unknown",
+			Score:          -1,
+			Explanation:    fmt.Sprintf("inspection failed: %v", err),
+			Action:         action,
+			InspectorModel: cfg.inspectorLabel(),
+			BackendModel:   model,
+			FromTool:       fromTool,
+			HasTools:       hasTools,
+			ToolResults:    toolResultsFrom(r.Context()),
+			InspectTimeMs:  inspectMs,
+			QueueWaitMs:    queueMs,
+			Signature:      signature,
+			ContentRoute:   routeLabel,
+		}
+		var parseErr *ParseError
+		if cfg.DebugInspector && errors.As(err, &parseErr) {
+			logEntry.RawResponse = storedRaw(cfg, parseErr.Raw)
+		}
+		span.SetAttributes(attribute.String("firewall.action", action))
+		if action == actionDropped {
+			// Client went away mid-inspection; don't spend backend compute on it
+			logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+			p.store.AddLog(logEntry)
+			return
+		}
+		if strings.HasPrefix(action, "rejected") {
+			span.SetStatus(codes.Error, action)
+			logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+			p.store.AddLog(logEntry)
+			w.Header().Set("Retry-After", "1")
+			writeJSONError(w, http.StatusServiceUnavailable, errOverloaded, "AI Context Firewall is overloaded, try again shortly")
+			return
+		}
+		if strings.HasPrefix(action, "timed out") {
+			span.SetStatus(codes.Error, action)
+			logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+			p.store.AddLog(logEntry)
+			writeTimeout(w, cfg, errInspectionTimeout)
+			return
+		}
+		if failClosed {
+			span.SetStatus(codes.Error, action)
+			logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+			p.store.AddLog(logEntry)
+			// Don't echo the internal error to the client
+			explanation := "Inspection is unavailable and the firewall is set to fail closed."
+			if reason == "overloaded" {
+				explanation = "The inspection queue is full and the firewall is set to block under overload."
+			}
+			p.respondBlocked(w, r, &InspectionResult{RiskLevel: "unknown", Score: -1, Explanation: explanation}, action, model, stream)
+			return
+		}
+		backendStart := time.Now()
+		backendPrompt, backendEval := p.release(w, r, body, nil, spec)
+		if timedOut(r.Context()) {
+			logEntry.Action = "timed out (backend)"
+		}
+		logEntry.BackendPromptTokens = backendPrompt
+		logEntry.BackendEvalTokens = backendEval
+		logEntry.BackendTimeMs = time.Since(backendStart).Milliseconds()
+		logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+		scanReport.apply(&logEntry)
+		p.store.AddLog(logEntry)
+		return
+	}
+
+	action := "forwarded"
+	switch {
+	case sysVerdict.blocks(cfg) && result.Score < cfg.Threshold:
+		action = systemBlockedAction
+	case result.Score >= cfg.Threshold:
+		action = "blocked"
+	case cfg.QuarantineAt > 0 && result.Score >= cfg.QuarantineAt:
+		action = p.awaitApproval(r.Context(), cfg, logged, model, result)
+	case cfg.WarnAt > 0 && result.Score >= cfg.WarnAt:
+		action = "warned"
+	case sysVerdict.warns(cfg):
+		action = "warned"
+	}
+
+	logEntry := InspectionLog{
+		RequestID:           requestIDFrom(r.Context()),
+		Client:              clientID(r),
+		ClientIP:            clientIPFrom(r.Context()),
+		Content:             storedContent(cfg, logged),
+		RiskLevel:           result.RiskLevel,
+		RawRiskLevel:        result.RawRiskLevel,
+		Score:               result.Score,
+		Explanation:         result.Explanation,
+		Action:              action,
+		InspectorModel:      cfg.inspectorLabel(),
+		BackendModel:        model,
+		FromTool:            fromTool,
+		HasTools:            hasTools,
+		ToolResults:         toolResultsFrom(r.Context()),
+		InspectPromptTokens: result.PromptTokens,
+		InspectEvalTokens:   result.EvalTokens,
+		InspectTimeMs:       inspectMs,
+		QueueWaitMs:         queueMs,
+		PassScores:          result.PassScores,
+		Aggregation:         result.Aggregation,
+		MatchedRules:        result.MatchedRules,
+		ContentRoute:        routeLabel,
+		Categories:          result.Categories,
+		Confidence:          result.Confidence,
+		Spans:               storedSpans(cfg, result.Spans),
+		ModelScore:          result.ModelScore,
+		RuleScore:           result.RuleScore,
+		Fusion:              result.Fusion,
+		PrimaryScore:        result.PrimaryScore,
+		EscalationModel:     result.EscalationModel,
+		Chain:               result.Chain,
+		Signature:           signature,
+	}
+	sysVerdict.apply(&logEntry)
+	if cfg.DebugInspector {
+		logEntry.RawResponse = storedRaw(cfg, result.Raw)
+	}
+
+	span.SetAttributes(
+		attribute.String("firewall.action", action),
+		attribute.Int("firewall.score", result.Score),
+		attribute.String("firewall.risk_level", result.RiskLevel),
+	)
+
+	if action == actionDropped || action == quarantineTimedOut {
+		span.SetStatus(codes.Error, action)
+		logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+		p.store.AddLog(logEntry)
+		if action == quarantineTimedOut {
+			writeTimeout(w, cfg, errInspectionTimeout)
+		}
+		return
+	}
+
+	if strings.HasPrefix(action, "blocked") {
+		span.SetStatus(codes.Error, action)
+		logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+		p.store.AddLog(logEntry)
+		reqLogf(r.Context(), "BLOCKED request (%s, score %d, threshold %d, inspect %dms, queue %dms, total %dms): %s",
+			action, result.Score, cfg.Threshold, inspectMs, queueMs, logEntry.TotalTimeMs, truncate(storedContent(cfg, logged), 80))
+		if action == systemBlockedAction {
+			result = sysVerdict.result
+		}
+		p.respondBlocked(w, r, result, action, model, stream)
+		return
+	}
+
+	// Warnings are injected in Ollama's response shape only
+	var warning *responseWarning
+	if action == "warned" && r.URL.Path != anthropicMessagesPath {
+		warned := result
+		if result.Score < cfg.WarnAt {
+			warned = sysVerdict.result
+		}
+		warning = &responseWarning{
+			text:   renderWarning(cfg.WarnTemplate, warned),
+			model:  model,
+			isChat: r.URL.Path == "/api/chat",
+			append: cfg.WarnPosition == "append",
+		}
+	}
+
+	backendStart := time.Now()
+	backendPrompt, backendEval := p.release(w, r, body, warning, spec)
+	backendMs := time.Since(backendStart).Milliseconds()
+	if timedOut(r.Context()) {
+		logEntry.Action = "timed out (backend)"
+	}
+
+	logEntry.BackendPromptTokens = backendPrompt
+	logEntry.BackendEvalTokens = backendEval
+	logEntry.BackendTimeMs = backendMs
+	logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+	scanReport.apply(&logEntry)
+	p.store.AddLog(logEntry)
+
+	reqLogf(r.Context(), "%s request (score %d, inspect %dms, queue %dms, backend %dms, total %dms): %s",
+		strings.ToUpper(logEntry.Action), result.Score, inspectMs, queueMs, backendMs, logEntry.TotalTimeMs, truncate(storedContent(cfg, logged), 80))
+}
+
+// actionDropped is the final action of a request whose client went away
+// before it was answered.
+const actionDropped = "dropped (client disconnected)"
+
+// forwardUninspected relays a request that was deliberately not inspected and
+// logs it with the given entry, filling in backend stats and timing.
+func (p *Proxy) forwardUninspected(w http.ResponseWriter, r *http.Request, body []byte, totalStart time.Time, logEntry InspectionLog) {
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("firewall.action", logEntry.Action))
+	backendStart := time.Now()
+	logEntry.BackendPromptTokens, logEntry.BackendEvalTokens = p.forward(w, r, body, nil)
+	logEntry.BackendTimeMs = time.Since(backendStart).Milliseconds()
+	logEntry.TotalTimeMs = time.Since(totalStart).Milliseconds()
+	logEntry.RequestID = requestIDFrom(r.Context())
+	logEntry.Client = clientID(r)
+	logEntry.ClientIP = clientIPFrom(r.Context())
+	logEntry.ToolResults = toolResultsFrom(r.Context())
+	scanReportFrom(r.Context()).apply(&logEntry)
+	p.store.AddLog(logEntry)
+	reqLogf(r.Context(), "%s request (total %dms)", strings.ToUpper(logEntry.Action), logEntry.TotalTimeMs)
+}
+
+// blockInfo is the "firewall" object on the final chunk of a blocked
+// response, for clients that react to blocks without parsing the message.
+type blockInfo struct {
+	Action     string   `json:"action"`
+	Score      int      `json:"score"`
+	RiskLevel  string   `json:"risk_level"`
+	Categories []string `json:"categories,omitempty"`
+	RequestID  string   `json:"request_id,omitempty"`
+}
+
+// respondBlocked answers in place of the backend. Streaming clients get the
+// message as NDJSON, a content chunk followed by a done chunk, since they parse
+// the body line by line. The status is BlockStatusCode either way.
+func (p *Proxy) respondBlocked(w http.ResponseWriter, r *http.Request, result *InspectionResult, action, model string, stream bool) {
+	// Check if the original request was for /api/chat or /api/generate to return the right format
+	cfg := p.store.GetConfig()
+	msg := renderBlockMessage(cfg, result)
+	if r.URL.Path == anthropicMessagesPath {
+		respondBlockedAnthropic(w, r, msg, model, stream, cfg.BlockStatusCode)
+		return
+	}
+	isChat := r.URL.Path == "/api/chat"
+
+	chunk := func(text string, done bool) map[string]any {
+		c := map[string]any{
+			"model":      model,
+			"created_at": "0001-01-01T00:00:00Z",
+			"done":       done,
+		}
+		if isChat {
+			c["message"] = map[string]string{"role": "assistant", "content": text}
+		} else {
+			c["response"] = text
+		}
+		if done {
+			c["done_reason"] = "blocked"
+			c["firewall"] = blockInfo{
+				Action:     action,
+				Score:      result.Score,
+				RiskLevel:  result.RiskLevel,
+				Categories: result.Categories,
+				RequestID:  requestIDFrom(r.Context()),
+			}
+		}
+		return c
+	}
+
+	if !stream {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(cfg.BlockStatusCode)
+		json.NewEncoder(w).Encode(chunk(msg, true))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(cfg.BlockStatusCode)
+	enc := json.NewEncoder(w)
+	enc.Encode(chunk(msg, false))
+	enc.Encode(chunk("", true))
+}
+
+// tokenTailBytes is how much of a streamed response is kept for reading the
+// token counts from its final chunk.
+const tokenTailBytes = 64 << 10
+
+// tailBuffer keeps roughly the last max bytes written to it, trimmed at line
+// boundaries so the final NDJSON chunk stays intact. A single line, such as a
+// non-streaming response, is kept whole.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*t.max {
+		// The last byte is skipped so a trailing newline can't empty the buffer
+		if i := bytes.IndexByte(t.buf[len(t.buf)-t.max:len(t.buf)-1], '\n'); i >= 0 {
+			t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max+i+1:]...)
+		}
+	}
+	return len(p), nil
+}
+
+// tokens returns the prompt and eval counts from the buffered response, or
+// zeros when token tracking is off.
+func (t *tailBuffer) tokens() (prompt, eval int) {
+	if t == nil {
+		return 0, 0
+	}
+	return extractTokens(t.buf)
+}
+
+// extractTokens reads the token counts from a backend response. Ollama puts
+// them on the final done chunk, so only the last non-empty line is parsed; a
+// non-streaming response is a single object, parsed whole if that line alone
+// isn't valid JSON.
+func extractTokens(data []byte) (prompt, eval int) {
+	var chunk struct {
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
+	}
+	data = bytes.TrimRight(data, " \t\r\n")
+	last := data[bytes.LastIndexByte(data, '\n')+1:]
+	if json.Unmarshal(last, &chunk) != nil {
+		json.Unmarshal(data, &chunk)
+	}
+	return chunk.PromptEvalCount, chunk.EvalCount
+}
+
+// forward relays the request to the backend and streams the response back,
+// returning the backend's prompt/eval token counts. A non-nil warning is
+// injected into the response.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, body []byte, warning *responseWarning) (int, int) {
+	cfg := p.store.GetConfig()
+	targetURL := cfg.BackendURL + r.URL.Path
+	if r.URL.RawQuery != "" {
+		targetURL += "?" + r.URL.RawQuery
+	}
+
+	ctx, span := tracer.Start(r.Context(), "forward", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := p.sendToBackend(ctx, r, cfg, targetURL, body)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if timedOut(ctx) {
+			writeTimeout(w, cfg, errBackendTimeout)
+			return 0, 0
+		}
+		writeJSONError(w, http.StatusBadGateway, errBackendUnavailable, fmt.Sprintf("backend error: %v", err))
+		return 0, 0
+	}
+	// A speculative response is released only after inspection, which
+	// still has to finish in time
+	if _, speculative := w.(*captureWriter); !speculative {
+		responseStarted(ctx)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode == http.StatusNotFound {
+		// Replace Ollama's terse "model not found" with where and how to fix it
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if isModelNotFound(resp.StatusCode, data) {
+			err := &ModelNotFoundError{Role: "backend", Model: requestModel(body), URL: cfg.BackendURL}
+			span.SetStatus(codes.Error, "model not found")
+			reqLogf(r.Context(), "%v", err)
+			writeJSONError(w, http.StatusNotFound, errModelNotFound, err.Error())
+			return 0, 0
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+	}
+
+	// A stalled backend aborts the request once nothing has arrived for
+	// BackendReadTimeoutSec; a steady stream can run as long as it needs
+	var respBody io.Reader = resp.Body
+	if cfg.BackendReadTimeoutSec > 0 {
+		idle := newIdleTimeoutReader(resp.Body, time.Duration(cfg.BackendReadTimeoutSec)*time.Second, cancel)
+		defer idle.stop()
+		respBody = idle
+	}
+
+	// Keep the end of the response, where the token counts are, unless
+	// nobody needs them
+	var tail *tailBuffer
+	if cfg.TrackTokens {
+		tail = &tailBuffer{max: tokenTailBytes}
+		respBody = io.TeeReader(respBody, tail)
+	}
+
+	// A scanned response is held back until complete, so a secret split
+	// across stream chunks is still caught
+	src := respBody
+	if scansResponse(cfg, r, resp) {
+		data, tooLarge, err := readUpTo(respBody, cfg.ResponseScanMaxBytes)
+		if err != nil {
+			span.RecordError(err)
+			writeJSONError(w, http.StatusBadGateway, errBackendUnavailable, "backend error: "+err.Error())
+			return 0, 0
+		}
+		stream := strings.Contains(resp.Header.Get("Content-Type"), "ndjson")
+		switch {
+		case !tooLarge:
+			scanned := p.scanResponse(ctx, cfg, r, body, data, stream)
+			if scanned == nil {
+				span.SetStatus(codes.Error, "blocked (response leak)")
+				p.respondBlocked(w, r, &InspectionResult{RiskLevel: "unknown", Score: -1, Explanation: "The response was withheld because it appears to contain secrets."}, "blocked (response leak)", requestModel(body), stream)
+				return tail.tokens()
+			}
+			if !bytes.Equal(scanned, data) {
+				resp.Header.Del("Content-Length")
+			}
+			src = bytes.NewReader(scanned)
+		case cfg.ResponseScan == "block":
+			// Too big to hold back: block mode fails closed...
+			if report := scanReportFrom(ctx); report != nil {
+				report.Blocked, report.TooLarge = true, true
+			}
+			reqLogf(ctx, "BLOCKED response over response_scan_max_bytes (%d), too large to scan", cfg.ResponseScanMaxBytes)
+			span.SetStatus(codes.Error, "blocked (response too large)")
+			p.respondBlocked(w, r, &InspectionResult{RiskLevel: "unknown", Score: -1, Explanation: "The response was withheld because it is too large to scan for secrets."}, "blocked (response too large)", requestModel(body), stream)
+			return tail.tokens()
+		default:
+			// ...and redact mode sends what was read and the rest unscanned
+			reqLogf(ctx, "response over response_scan_max_bytes (%d), forwarding it unscanned", cfg.ResponseScanMaxBytes)
+			src = io.MultiReader(bytes.NewReader(data), respBody)
+		}
+	}
+
+	// Copy response headers; the request ID was already set by ServeHTTP
+	resp.Header.Del(requestIDHeader)
+	copyHeaders(w.Header(), resp.Header, cfg.responseHeaderPolicy())
+
+	// The snippet is taken after scanning, so it holds what the client got
+	var snippet *snippetWriter
+	report := scanReportFrom(ctx)
+	if cfg.LogResponseSnippet > 0 && report != nil && resp.StatusCode == http.StatusOK &&
+		(r.URL.Path == "/api/chat" || r.URL.Path == "/api/generate") {
+		snippet = &snippetWriter{max: cfg.LogResponseSnippet, isChat: r.URL.Path == "/api/chat"}
+		src = io.TeeReader(src, snippet)
+	}
+
+	if warning != nil {
+		copyWithWarning(w, resp, src, warning)
+	} else {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, src)
+	}
+	if snippet != nil {
+		report.Snippet = responseSnippet(cfg, snippet.snippet())
+	}
+	promptTokens, evalTokens := tail.tokens()
+	span.SetAttributes(
+		attribute.Int("firewall.backend_prompt_tokens", promptTokens),
+		attribute.Int("firewall.backend_eval_tokens", evalTokens),
+	)
+	return promptTokens, evalTokens
+}
+
+// readUpTo reads r to the end, or only max+1 bytes when max is positive and
+// r is longer, reporting whether it stopped early.
+func readUpTo(r io.Reader, max int64) ([]byte, bool, error) {
+	if max <= 0 {
+		data, err := io.ReadAll(r)
+		return data, false, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	return data, int64(len(data)) > max, err
+}
+
+func truncate(s string, maxLen int) string {
+	// Replace newlines for log readability
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len(s) > maxLen {
+		return s[:maxLen] + "..."
+	}
+	return s
+}
+
+// truncateRaw shortens a raw inspector reply for storage. Unlike truncate it
+// keeps newlines, since the exact formatting matters when debugging parse failures.
+// A maxLen of zero or less keeps the full reply.
+func truncateRaw(s string, maxLen int) string {
+	if maxLen > 0 && len(s) > maxLen {
+		return s[:maxLen] + "..."
+	}
+	return s
+}
+
+// storedContent applies the configured redaction and length policy to request
+// content before it is logged. Hash-only logging hashes the original content.
+func storedContent(cfg Config, content string) string {
+	if cfg.RedactLogs && cfg.LogContentChars >= 0 {
+		content = redactForLog(content)
+	}
+	return logContent(content, cfg.LogContentChars)
+}
+
+// storedSpans applies the content policy to flagged phrases, which quote the
+// content: redacted with it, and dropped under hash-only logging.
+func storedSpans(cfg Config, spans []string) []string {
+	if cfg.LogContentChars < 0 {
+		return nil
+	}
+	if !cfg.RedactLogs {
+		return spans
+	}
+	out := make([]string, len(spans))
+	for i, s := range spans {
+		out[i] = redactForLog(s)
+	}
+	return out
+}
+
+// storedRaw applies redaction and the length limit to a raw inspector reply.
+func storedRaw(cfg Config, raw string) string {
+	if cfg.RedactLogs {
+		raw = redactForLog(raw)
+	}
+	return truncateRaw(raw, cfg.RawResponseChars)
+}
+
+// logContent prepares request content for storage in the log: truncated to
+// maxLen characters, stored in full when maxLen is 0, or replaced by its
+// SHA-256 hash when maxLen is negative.
+func logContent(content string, maxLen int) string {
+	switch {
+	case maxLen < 0:
+		sum := sha256.Sum256([]byte(content))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	case maxLen == 0:
+		return content
+	default:
+		return truncate(content, maxLen)
+	}
+}
@@ -0,0 +1,163 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// modelPuller pulls a missing inspector model with Ollama's /api/pull when
+// AutoPullModel is set. It checks once per inspector host and model, so a
+// config change to another model triggers a new check, and pulls again when
+// inspection reports the model missing.
+type modelPuller struct {
+	mu      sync.Mutex
+	checked string
+	pulling map[string]bool
+}
+
+func pullKey(cfg Config) string {
+	return cfg.InspectorURL + " " + cfg.InspectorModel
+}
+
+func canAutoPull(cfg Config) bool {
+	return cfg.AutoPullModel && cfg.InspectorMode != "heuristic" && cfg.InspectorType != "openai" && cfg.InspectorModel != ""
+}
+
+// watch starts a background check-and-pull the first time it sees an
+// inspector host and model.
+func (mp *modelPuller) watch(cfg Config) {
+	if !canAutoPull(cfg) {
+		return
+	}
+	key := pullKey(cfg)
+	mp.mu.Lock()
+	if mp.checked == key {
+		mp.mu.Unlock()
+		return
+	}
+	mp.checked = key
+	mp.mu.Unlock()
+
+	go func() {
+		models, err := fetchModelList(modelListKey{url: cfg.InspectorURL}, cfg.InspectorAPIKey)
+		if err != nil {
+			log.Printf("auto-pull: cannot list models, will pull when inspection reports %q missing: %v", cfg.InspectorModel, err)
+			return
+		}
+		if !modelListed(models, cfg.InspectorModel) {
+			mp.pull(cfg)
+		}
+	}()
+}
+
+// notFound starts a background pull after inspection found the model missing.
+func (mp *modelPuller) notFound(cfg Config) {
+	if canAutoPull(cfg) {
+		go mp.pull(cfg)
+	}
+}
+
+// pull runs one pull to completion, logging progress. Concurrent pulls of
+// the same model collapse into one.
+func (mp *modelPuller) pull(cfg Config) {
+	key := pullKey(cfg)
+	mp.mu.Lock()
+	if mp.pulling == nil {
+		mp.pulling = map[string]bool{}
+	}
+	if mp.pulling[key] {
+		mp.mu.Unlock()
+		return
+	}
+	mp.pulling[key] = true
+	mp.mu.Unlock()
+	defer func() {
+		mp.mu.Lock()
+		delete(mp.pulling, key)
+		mp.mu.Unlock()
+	}()
+
+	start := time.Now()
+	log.Printf("auto-pull: pulling %q on %s", cfg.InspectorModel, cfg.InspectorURL)
+	if err := pullModel(cfg); err != nil {
+		log.Printf("auto-pull: pulling %q failed after %s: %v", cfg.InspectorModel, time.Since(start).Round(time.Second), err)
+		return
+	}
+	log.Printf("auto-pull: %q is ready (%s)", cfg.InspectorModel, time.Since(start).Round(time.Second))
+}
+
+// pullModel streams POST /api/pull, logging each new status and every 10% of
+// a layer download.
+func pullModel(cfg Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.PullTimeoutSec)*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{"model": cfg.InspectorModel, "stream": true})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.InspectorURL+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.InspectorAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.InspectorAPIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %ds", cfg.PullTimeoutSec)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("inspector returned %d", resp.StatusCode)
+	}
+
+	var lastStatus string
+	lastPct := -1
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var p struct {
+			Status    string `json:"status"`
+			Error     string `json:"error"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &p) != nil {
+			continue
+		}
+		if p.Error != "" {
+			return errors.New(p.Error)
+		}
+		if p.Status != lastStatus {
+			lastStatus, lastPct = p.Status, -1
+			if p.Total == 0 {
+				log.Printf("auto-pull: %s", p.Status)
+			}
+		}
+		if p.Total > 0 {
+			if pct := int(p.Completed * 100 / p.Total); pct/10 > lastPct/10 {
+				lastPct = pct
+				log.Printf("auto-pull: %s %d%% of %d MB", p.Status, pct, p.Total>>20)
+			}
+		}
+		if p.Status == "success" {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %ds", cfg.PullTimeoutSec)
+		}
+		return err
+	}
+	return errors.New("pull ended without success")
+}
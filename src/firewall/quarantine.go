@@ -0,0 +1,124 @@
+package firewall
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// QuarantineEntry is a request held for manual approval. The proxy handler
+// waits on decision until an operator approves or denies it, or it times out.
+type QuarantineEntry struct {
+	ID           int       `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Content      string    `json:"content"`
+	RiskLevel    string    `json:"risk_level"`
+	Score        int       `json:"score"`
+	Explanation  string    `json:"explanation"`
+	BackendModel string    `json:"backend_model"`
+	ExpiresAt    time.Time `json:"expires_at"`
+
+	decision chan bool
+}
+
+// AddQuarantine registers a held request and returns its ID and the channel
+// its decision will be delivered on.
+func (s *Store) AddQuarantine(e QuarantineEntry) (int, <-chan bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quarantine == nil {
+		s.quarantine = make(map[int]*QuarantineEntry)
+	}
+	e.ID = s.nextQuarantineID
+	s.nextQuarantineID++
+	e.Timestamp = time.Now()
+	e.decision = make(chan bool, 1)
+	s.quarantine[e.ID] = &e
+	return e.ID, e.decision
+}
+
+// ListQuarantine returns pending entries, oldest first.
+func (s *Store) ListQuarantine() []QuarantineEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]QuarantineEntry, 0, len(s.quarantine))
+	for _, e := range s.quarantine {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// ResolveQuarantine delivers an operator decision. It returns false if the
+// entry is no longer pending (already decided, timed out, or client gone).
+func (s *Store) ResolveQuarantine(id int, approve bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.quarantine[id]
+	if !ok {
+		return false
+	}
+	delete(s.quarantine, id)
+	e.decision <- approve
+	return true
+}
+
+// RemoveQuarantine drops a pending entry without a decision.
+func (s *Store) RemoveQuarantine(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.quarantine, id)
+}
+
+// quarantineTimedOut is the final action of a quarantined request whose
+// request deadline passed before a decision or the quarantine timeout.
+const quarantineTimedOut = "timed out (quarantine)"
+
+// awaitApproval parks the request until an operator decides, the quarantine
+// timeout expires, or the client disconnects. It returns the final action for
+// the log: actionDropped or quarantineTimedOut if the request context
+// ended first.
+func (p *Proxy) awaitApproval(ctx context.Context, cfg Config, content, model string, result *InspectionResult) string {
+	timeout := time.Duration(cfg.QuarantineTimeoutSec) * time.Second
+	id, decision := p.store.AddQuarantine(QuarantineEntry{
+		Content:      truncate(storedContent(cfg, content), 500),
+		RiskLevel:    result.RiskLevel,
+		Score:        result.Score,
+		Explanation:  result.Explanation,
+		BackendModel: model,
+		ExpiresAt:    time.Now().Add(timeout),
+	})
+	reqLogf(ctx, "QUARANTINED request #%d (score %d), waiting up to %s for approval: %s", id, result.Score, timeout, truncate(storedContent(cfg, content), 80))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case approved := <-decision:
+		if approved {
+			reqLogf(ctx, "quarantined request #%d approved", id)
+			return "forwarded (approved)"
+		}
+		reqLogf(ctx, "quarantined request #%d denied", id)
+		return "blocked (denied)"
+	case <-timer.C:
+		p.store.RemoveQuarantine(id)
+		if cfg.QuarantineDefault == "forward" {
+			reqLogf(ctx, "quarantined request #%d timed out, forwarding", id)
+			return "forwarded (quarantine timeout)"
+		}
+		reqLogf(ctx, "quarantined request #%d timed out, blocking", id)
+		return "blocked (quarantine timeout)"
+	case <-ctx.Done():
+		p.store.RemoveQuarantine(id)
+		if timedOut(ctx) {
+			reqLogf(ctx, "request deadline passed while quarantined request #%d was pending", id)
+			return quarantineTimedOut
+		}
+		reqLogf(ctx, "client disconnected while quarantined request #%d was pending, dropping it", id)
+		return actionDropped
+	}
+}
@@ -0,0 +1,166 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded is returned by Inspect when QueueDepth calls are already
+// waiting for an inspection worker. OverloadPolicy decides what the proxy
+// does with the request.
+var ErrOverloaded = errors.New("inspection queue full")
+
+// inspectTiming adds up, for one request, how long its inspector calls
+// waited for a slot and how long the calls themselves took, so saturation
+// can be told apart from a slow model.
+type inspectTiming struct {
+	wait atomic.Int64
+	call atomic.Int64
+}
+
+type inspectTimingKey struct{}
+
+func withInspectTiming(ctx context.Context) (context.Context, *inspectTiming) {
+	t := &inspectTiming{}
+	return context.WithValue(ctx, inspectTimingKey{}, t), t
+}
+
+// recordInspectTiming adds one call's timing to the request's totals, if ctx has any.
+func recordInspectTiming(ctx context.Context, wait, call time.Duration) {
+	if t, ok := ctx.Value(inspectTimingKey{}).(*inspectTiming); ok {
+		t.wait.Add(int64(wait))
+		t.call.Add(int64(call))
+	}
+}
+
+// ms returns the queue wait and call time in milliseconds.
+func (t *inspectTiming) ms() (waitMs, callMs int64) {
+	return time.Duration(t.wait.Load()).Milliseconds(), time.Duration(t.call.Load()).Milliseconds()
+}
+
+type inspectJob struct {
+	ctx  context.Context
+	run  func()
+	done chan struct{}
+}
+
+// workerPool runs inspector calls on a fixed number of workers fed by a
+// bounded queue. Offers never block, so a spike is refused up front instead
+// of piling up goroutines.
+type workerPool struct {
+	workers int
+	depth   int
+	jobs    chan *inspectJob
+
+	mu      sync.RWMutex
+	retired bool
+}
+
+func newWorkerPool(ins *Inspector, workers, depth int) *workerPool {
+	p := &workerPool{workers: workers, depth: depth, jobs: make(chan *inspectJob, depth)}
+	for range workers {
+		go p.work(ins)
+	}
+	return p
+}
+
+func (p *workerPool) work(ins *Inspector) {
+	for job := range p.jobs {
+		ins.queued.Add(-1)
+		// The caller gave up while the job was queued
+		if job.ctx.Err() == nil {
+			job.run()
+		}
+		ins.inFlight.Add(-1)
+		close(job.done)
+	}
+}
+
+// offer queues job. It reports false when the queue is full, and retired
+// when the pool has been replaced and the job should go to the new one.
+func (p *workerPool) offer(job *inspectJob) (ok, retired bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.retired {
+		return false, true
+	}
+	select {
+	case p.jobs <- job:
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// retire stops the pool once its queued jobs have run.
+func (p *workerPool) retire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retired = true
+	close(p.jobs)
+}
+
+// workerPool returns the pool for the configured size, replacing the current
+// one when MaxConcurrentInspections or QueueDepth changed.
+func (ins *Inspector) workerPool(workers, depth int) *workerPool {
+	ins.semMu.Lock()
+	defer ins.semMu.Unlock()
+	if ins.pool == nil || ins.pool.workers != workers || ins.pool.depth != depth {
+		if ins.pool != nil {
+			ins.pool.retire()
+		}
+		ins.pool = newWorkerPool(ins, workers, depth)
+	}
+	return ins.pool
+}
+
+// runLimited runs call within MaxConcurrentInspections. With a QueueDepth it
+// runs on the worker pool and fails with ErrOverloaded when the queue is
+// full; otherwise it waits for a slot for as long as ctx allows. The wait
+// and the call are timed separately (see inspectTiming).
+func (ins *Inspector) runLimited(ctx context.Context, cfg Config, call func()) error {
+	queuedAt := time.Now()
+	timed := func() {
+		start := time.Now()
+		call()
+		recordInspectTiming(ctx, start.Sub(queuedAt), time.Since(start))
+	}
+
+	if cfg.MaxConcurrentInspections <= 0 || cfg.QueueDepth <= 0 {
+		release, err := ins.acquire(ctx, cfg.MaxConcurrentInspections)
+		if err != nil {
+			return err
+		}
+		defer release()
+		timed()
+		return nil
+	}
+
+	job := &inspectJob{ctx: ctx, run: timed, done: make(chan struct{})}
+	ins.inFlight.Add(1)
+	ins.queued.Add(1)
+	for {
+		ok, retired := ins.workerPool(cfg.MaxConcurrentInspections, cfg.QueueDepth).offer(job)
+		if retired {
+			continue
+		}
+		if !ok {
+			ins.queued.Add(-1)
+			ins.inFlight.Add(-1)
+			ins.rejected.Add(1)
+			return ErrOverloaded
+		}
+		break
+	}
+
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for inspection worker: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,75 @@
+package firewall
+
+import (
+	"regexp"
+	"strings"
+)
+
+type redactPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// redactPatterns match common secrets and PII. Order matters: more specific
+// patterns (private keys, JWTs) run before generic ones that could match a
+// fragment of them.
+var redactPatterns = []redactPattern{
+	{"private_key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]+`)},
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws_secret_key", regexp.MustCompile(`(?i)(aws_secret_access_key|aws_secret_key|secret_access_key)(\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`)},
+	{"bearer_token", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]{8,}=*`)},
+	{"api_key", regexp.MustCompile(`\b(?:sk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|xox[abprs]-[A-Za-z0-9-]{10,}|AIza[0-9A-Za-z_-]{35})`)},
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{"credit_card", regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)},
+}
+
+// redactForLog masks secrets and PII in s before it is stored. It never
+// touches what the inspector sees, only what ends up in the log.
+func redactForLog(s string) string {
+	for _, p := range redactPatterns {
+		switch p.name {
+		case "aws_secret_key":
+			// Keep the key name so the log still shows what kind of value was there
+			s = p.re.ReplaceAllString(s, "${1}${2}[REDACTED:aws_secret_key]")
+		case "credit_card":
+			s = p.re.ReplaceAllStringFunc(s, func(m string) string {
+				if luhnValid(m) {
+					return "[REDACTED:credit_card]"
+				}
+				return m
+			})
+		default:
+			s = p.re.ReplaceAllString(s, "[REDACTED:"+p.name+"]")
+		}
+	}
+	return s
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum, which
+// filters out most long numbers that aren't card numbers.
+func luhnValid(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,62 @@
+package firewall
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// newRequestID returns a random UUIDv4.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// validRequestID accepts client-supplied IDs that are short and printable,
+// so they can't be used to inject into log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID takes the request ID from the incoming X-Request-ID header or
+// generates one, sets it on the request headers (so it is forwarded to the
+// backend), echoes it on the response, and stores it in the request context.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+		r.Header.Set(requestIDHeader, id)
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// reqLogf logs with the request ID from ctx as a prefix.
+func reqLogf(ctx context.Context, format string, args ...any) {
+	if id := requestIDFrom(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
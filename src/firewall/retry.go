@@ -0,0 +1,106 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// retryable reports whether a backend attempt failed in a way a restarting
+// backend produces: a connection that couldn't be made or was refused, reset
+// or closed before any response, or a 502/503. Timeouts aren't retried, since
+// each retry could wait out the whole timeout again.
+func retryable(resp *http.Response, err error) bool {
+	if err == nil {
+		return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF)
+}
+
+// canRetry reports whether a request can safely be sent again: bodyless
+// GET/HEAD requests, and buffered bodies that don't ask for a stream.
+func canRetry(r *http.Request, body []byte) bool {
+	if body == nil {
+		return r.Method == http.MethodGet || r.Method == http.MethodHead
+	}
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+	return !isStreaming(req.Stream)
+}
+
+// sendToBackend sends the request to the backend. Retryable requests get up
+// to BackendRetries more attempts, with a backoff that doubles each time, on
+// connection errors and 502/503, as long as the request deadline leaves room
+// for the backoff. Nothing has been written to the client at that point, so
+// the retry is invisible to it.
+func (p *Proxy) sendToBackend(ctx context.Context, r *http.Request, cfg Config, targetURL string, body []byte) (*http.Response, error) {
+	retries := 0
+	if cfg.BackendRetries > 0 && canRetry(r, body) {
+		retries = cfg.BackendRetries
+	}
+	backoff := time.Duration(cfg.BackendRetryBackoffMs) * time.Millisecond
+	client := p.client.get(backendClientSettings(cfg))
+
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		} else {
+			bodyReader = r.Body
+		}
+		proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+
+		copyHeaders(proxyReq.Header, r.Header, cfg.requestHeaderPolicy())
+		setForwardedHeaders(proxyReq.Header, r, cfg)
+		injectTrace(ctx, proxyReq.Header)
+
+		resp, err := client.Do(proxyReq)
+		deadline, hasDeadline := ctx.Deadline()
+		outOfTime := hasDeadline && time.Until(deadline) <= backoff
+		if attempt >= retries || ctx.Err() != nil || outOfTime || !retryable(resp, err) {
+			switch {
+			case attempt == 0:
+			case err != nil:
+				reqLogf(r.Context(), "backend still failing after %d retries: %v", attempt, err)
+			default:
+				reqLogf(r.Context(), "backend answered %d after %d retries", resp.StatusCode, attempt)
+			}
+			return resp, err
+		}
+
+		if err != nil {
+			reqLogf(r.Context(), "backend attempt %d/%d failed: %v, retrying in %s", attempt+1, retries+1, err, backoff)
+		} else {
+			reqLogf(r.Context(), "backend attempt %d/%d returned %d, retrying in %s", attempt+1, retries+1, resp.StatusCode, backoff)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
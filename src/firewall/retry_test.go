@@ -0,0 +1,46 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	wrap := func(err error) error { return &url.Error{Op: "Post", URL: "http://backend/api/chat", Err: err} }
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{"502", http.StatusBadGateway, nil, true},
+		{"503", http.StatusServiceUnavailable, nil, true},
+		{"500", http.StatusInternalServerError, nil, false},
+		{"dial error", 0, wrap(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}), true},
+		{"connection refused", 0, wrap(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"connection reset", 0, wrap(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"closed before response", 0, wrap(io.EOF), true},
+		{"dial timeout", 0, wrap(&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}), false},
+		{"response header timeout", 0, wrap(os.ErrDeadlineExceeded), false},
+		{"request deadline", 0, wrap(context.DeadlineExceeded), false},
+		{"other error", 0, wrap(errors.New("malformed HTTP response")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+			if got := retryable(resp, tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
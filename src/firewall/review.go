@@ -0,0 +1,93 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Review labels record what a reviewer found an entry's content to be,
+// regardless of what the firewall did with it.
+const (
+	reviewAttack = "attack"
+	reviewBenign = "benign"
+)
+
+// detected reports whether the inspector flagged the entry: it was blocked
+// or warned on a verdict. Failing closed and blocked responses don't count.
+func (l InspectionLog) detected() bool {
+	return l.Score >= 0 && l.Action != "blocked (response leak)" &&
+		(strings.HasPrefix(l.Action, "blocked") || l.Action == "warned")
+}
+
+// SetReviewLabel labels a log entry as an attack or benign, or clears the
+// label with "". Labelling a flagged entry benign also marks it a false
+// positive.
+func (s *Store) SetReviewLabel(id int, label string) (InspectionLog, error) {
+	if label != "" && label != reviewAttack && label != reviewBenign {
+		return InspectionLog{}, fmt.Errorf("label must be %s or %s", reviewAttack, reviewBenign)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.logs {
+		if s.logs[i].ID == id {
+			s.logs[i].ReviewLabel = label
+			s.logs[i].FalsePositive = label == reviewBenign && s.logs[i].detected()
+			return s.logs[i], nil
+		}
+	}
+	return InspectionLog{}, errLogNotFound
+}
+
+// ReviewStats measures the block and warn decisions against reviewed
+// entries. Only reviewed entries count, so recall is an estimate bounded by
+// how many forwarded requests get reviewed. Precision and Recall are nil
+// until there is something to divide by.
+type ReviewStats struct {
+	Reviewed       int      `json:"reviewed"`
+	TruePositives  int      `json:"true_positives"`
+	FalsePositives int      `json:"false_positives"`
+	TrueNegatives  int      `json:"true_negatives"`
+	FalseNegatives int      `json:"false_negatives"`
+	Precision      *float64 `json:"precision"`
+	Recall         *float64 `json:"recall"`
+}
+
+func (r *ReviewStats) add(l InspectionLog) {
+	if l.ReviewLabel == "" {
+		return
+	}
+	r.Reviewed++
+	switch attack := l.ReviewLabel == reviewAttack; {
+	case l.detected() && attack:
+		r.TruePositives++
+	case l.detected():
+		r.FalsePositives++
+	case attack:
+		r.FalseNegatives++
+	default:
+		r.TrueNegatives++
+	}
+}
+
+func (r *ReviewStats) finish() {
+	ratio := func(a, b int) *float64 {
+		if a+b == 0 {
+			return nil
+		}
+		v := float64(a) / float64(a+b)
+		return &v
+	}
+	r.Precision = ratio(r.TruePositives, r.FalsePositives)
+	r.Recall = ratio(r.TruePositives, r.FalseNegatives)
+}
+
+// PrecisionText and RecallText format the ratios for the dashboard.
+func (r ReviewStats) PrecisionText() string { return percentText(r.Precision) }
+func (r ReviewStats) RecallText() string    { return percentText(r.Recall) }
+
+func percentText(v *float64) string {
+	if v == nil {
+		return "—"
+	}
+	return fmt.Sprintf("%.0f%%", *v*100)
+}
@@ -0,0 +1,145 @@
+package firewall
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule flags content deterministically, whatever the inspector model thinks.
+// Pattern is matched case-insensitively, as a literal substring unless Regex
+// is set.
+type Rule struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Regex    bool   `json:"regex,omitempty"`
+	Score    int    `json:"score"`
+	Category string `json:"category,omitempty"`
+}
+
+// RuleMatch is a rule that matched inspected content.
+type RuleMatch struct {
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	Score    int    `json:"score"`
+}
+
+func (r Rule) compile() (*regexp.Regexp, error) {
+	if r.Regex {
+		return compileRule(r.Pattern)
+	}
+	return compileRule(regexp.QuoteMeta(r.Pattern))
+}
+
+func (r Rule) validate() error {
+	if r.Name == "" || r.Pattern == "" {
+		return fmt.Errorf("name and pattern are required")
+	}
+	if r.Score < 0 || r.Score > 100 {
+		return fmt.Errorf("score %d is outside 0-100", r.Score)
+	}
+	if _, err := r.compile(); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	return nil
+}
+
+// matchRules returns the configured rules that match content.
+func matchRules(cfg Config, content string) []RuleMatch {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	if cfg.NormalizeUnicode {
+		content = normalizeForInspection(content, cfg.MapHomoglyphs)
+	}
+	var matches []RuleMatch
+	for _, rule := range cfg.Rules {
+		re, err := rule.compile()
+		if err != nil {
+			continue
+		}
+		if re.MatchString(content) {
+			matches = append(matches, RuleMatch{Name: rule.Name, Category: rule.Category, Score: rule.Score})
+		}
+	}
+	return matches
+}
+
+func maxRuleScore(matches []RuleMatch) int {
+	score := 0
+	for _, m := range matches {
+		score = max(score, m.Score)
+	}
+	return score
+}
+
+// ruleNames lists matches as "name (category)".
+func ruleNames(matches []RuleMatch) []string {
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.Name
+		if m.Category != "" {
+			names[i] += " (" + m.Category + ")"
+		}
+	}
+	return names
+}
+
+// rulesDecide reports whether the matched rules settle the verdict on their
+// own under cfg.ScoreFusion, so the inspector model need not be called.
+func rulesDecide(cfg Config, matches []RuleMatch) bool {
+	if len(matches) == 0 {
+		return false
+	}
+	switch cfg.ScoreFusion {
+	case "rules_override":
+		return true
+	case "weighted_average":
+		return false
+	}
+	return maxRuleScore(matches) >= cfg.Threshold
+}
+
+// fuseScores combines the inspector verdict with the matched rules according
+// to cfg.ScoreFusion and records both inputs on the result. modelConsulted is
+// false when rulesDecide skipped the inspector, leaving only the rule score.
+//   - max (default): the higher of the two, so a rule can force a block but
+//     never lowers what the inspector found
+//   - weighted_average: RuleWeight percent rule score, the rest model score
+//   - rules_override: the rule score whenever a rule matched
+func fuseScores(cfg Config, result *InspectionResult, matches []RuleMatch, modelConsulted bool) {
+	if len(matches) == 0 {
+		return
+	}
+	method := cfg.ScoreFusion
+	if method == "" {
+		method = "max"
+	}
+	ruleScore := maxRuleScore(matches)
+	result.MatchedRules = ruleNames(matches)
+	for _, m := range matches {
+		if m.Category != "" {
+			result.Categories = append(result.Categories, m.Category)
+		}
+	}
+	result.Categories = normalizeCategories(result.Categories)
+	result.RuleScore = &ruleScore
+	result.Fusion = method
+
+	score := ruleScore
+	if modelConsulted {
+		modelScore := result.Score
+		result.ModelScore = &modelScore
+		switch method {
+		case "weighted_average":
+			score = (ruleScore*cfg.RuleWeight + modelScore*(100-cfg.RuleWeight) + 50) / 100
+		case "max":
+			score = max(ruleScore, modelScore)
+		}
+	}
+	if score != result.Score {
+		result.Score = score
+		result.RiskLevel = riskLevelFor(cfg, score)
+		result.Explanation = "Rule match: " + strings.Join(result.MatchedRules, ", ") + ". " + result.Explanation
+	}
+}
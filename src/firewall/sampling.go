@@ -0,0 +1,32 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+const actionUnsampled = "forwarded (unsampled)"
+
+// sampled reports whether a request is selected for inspection under
+// SampleRate. With SampleByContent the choice follows a hash of the content,
+// so the same prompt is always either inspected or not.
+func sampled(cfg Config, content string) bool {
+	if cfg.SampleRate >= 1 {
+		return true
+	}
+	if cfg.SampleRate <= 0 {
+		return false
+	}
+	if !cfg.SampleByContent {
+		return rand.Float64() < cfg.SampleRate
+	}
+	sum := sha256.Sum256([]byte(content))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < cfg.SampleRate
+}
+
+func unsampledExplanation(cfg Config) string {
+	return fmt.Sprintf("not selected for inspection (sample_rate %g)", cfg.SampleRate)
+}
@@ -0,0 +1,230 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fields of an inspector reply, by their default names.
+const (
+	fieldRiskLevel   = "risk_level"
+	fieldScore       = "score"
+	fieldExplanation = "explanation"
+	fieldCategories  = "categories"
+	fieldConfidence  = "confidence"
+	fieldSpans       = "spans"
+)
+
+var schemaFields = []string{fieldRiskLevel, fieldScore, fieldExplanation, fieldCategories, fieldConfidence, fieldSpans}
+
+// InspectorSchema describes the JSON reply a prompt asks the inspector for,
+// so prompt authors can rename fields or insist on them without code
+// changes.
+type InspectorSchema struct {
+	// Fields maps a field to the name the reply uses for it, e.g.
+	// {"score": "risk_score"}. Unmapped fields keep their default names.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Required fields must be in the reply; without them it counts as
+	// unparseable.
+	Required []string `json:"required,omitempty"`
+}
+
+func (s InspectorSchema) name(field string) string {
+	if n := s.Fields[field]; n != "" {
+		return n
+	}
+	return field
+}
+
+func (s InspectorSchema) validate() error {
+	for field := range s.Fields {
+		if !slices.Contains(schemaFields, field) {
+			return fmt.Errorf("inspector_schema: unknown field %q", field)
+		}
+	}
+	for _, field := range s.Required {
+		if !slices.Contains(schemaFields, field) {
+			return fmt.Errorf("inspector_schema: unknown required field %q", field)
+		}
+	}
+	return nil
+}
+
+func (s InspectorSchema) clone() InspectorSchema {
+	return InspectorSchema{Fields: maps.Clone(s.Fields), Required: slices.Clone(s.Required)}
+}
+
+// complete reports whether every required field was found.
+func (s InspectorSchema) complete(found map[string]bool) bool {
+	for _, field := range s.Required {
+		if !found[field] {
+			return false
+		}
+	}
+	return true
+}
+
+// fromJSON fills result from a decoded reply object and reports which fields
+// it found. Numbers may come as strings, categories as a comma-separated
+// string, and a single span as a plain string.
+func (s InspectorSchema) fromJSON(obj map[string]any, result *InspectionResult) map[string]bool {
+	found := map[string]bool{}
+	for _, field := range schemaFields {
+		v, ok := obj[s.name(field)]
+		if !ok || v == nil {
+			continue
+		}
+		switch field {
+		case fieldRiskLevel:
+			result.RiskLevel, ok = v.(string)
+		case fieldExplanation:
+			result.Explanation, ok = v.(string)
+		case fieldScore:
+			var n float64
+			if n, ok = number(v); ok {
+				result.Score = int(math.Round(n))
+			}
+		case fieldConfidence:
+			var n float64
+			if n, ok = number(v); ok {
+				result.Confidence = confidence(n)
+			}
+		case fieldCategories:
+			result.Categories, ok = stringList(v)
+		case fieldSpans:
+			if s, isString := v.(string); isString {
+				result.Spans = []string{s}
+			} else {
+				result.Spans, ok = stringList(v)
+			}
+		}
+		found[field] = ok
+	}
+	return found
+}
+
+// fromRegex recovers the fields not found yet from a reply that isn't valid
+// JSON, e.g. with an unescaped quote or cut off by the token limit.
+func (s InspectorSchema) fromRegex(raw string, result *InspectionResult, found map[string]bool) {
+	for _, field := range schemaFields {
+		if found[field] {
+			continue
+		}
+		m := fieldRegex(field, s.name(field)).FindStringSubmatch(raw)
+		if len(m) < 2 {
+			continue
+		}
+		setMatchedField(field, m[1], result)
+		found[field] = true
+	}
+}
+
+// setMatchedField stores a value captured by fieldRegex in result.
+func setMatchedField(field, value string, result *InspectionResult) {
+	switch field {
+	case fieldRiskLevel:
+		result.RiskLevel = strings.ToLower(value)
+	case fieldExplanation:
+		result.Explanation = value
+	case fieldScore:
+		result.Score, _ = strconv.Atoi(value)
+	case fieldConfidence:
+		n, _ := strconv.ParseFloat(value, 64)
+		result.Confidence = confidence(n)
+	case fieldCategories:
+		for _, q := range reQuoted.FindAllStringSubmatch(value, -1) {
+			result.Categories = append(result.Categories, q[1])
+		}
+	case fieldSpans:
+		for _, q := range reQuoted.FindAllStringSubmatch(value, -1) {
+			result.Spans = append(result.Spans, q[1])
+		}
+	}
+}
+
+var reQuoted = regexp.MustCompile(`"([^"]*)"`)
+
+// fieldPatterns are the regex fallbacks per field; %s is the quoted field name.
+var fieldPatterns = map[string]string{
+	fieldRiskLevel:   `"%s"\s*:\s*"(\w+)"`,
+	fieldScore:       `"%s"\s*:\s*"?(\d+)"?`,
+	fieldExplanation: `"%s"\s*:\s*"([^"]{0,500})`,
+	fieldCategories:  `"%s"\s*:\s*\[([^\]]*)\]`,
+	fieldSpans:       `"%s"\s*:\s*\[([^\]]*)\]`,
+	fieldConfidence:  `"%s"\s*:\s*"?(\d+(?:\.\d+)?)"?`,
+}
+
+var fieldRegexes sync.Map
+
+// fieldRegex returns the compiled fallback for a field under a name.
+func fieldRegex(field, name string) *regexp.Regexp {
+	key := field + "\x00" + name
+	if re, ok := fieldRegexes.Load(key); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(fmt.Sprintf(fieldPatterns[field], regexp.QuoteMeta(name)))
+	fieldRegexes.Store(key, re)
+	return re
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func stringList(v any) ([]string, bool) {
+	switch l := v.(type) {
+	case string:
+		return strings.Split(l, ","), true
+	case []any:
+		var out []string
+		for _, item := range l {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// confidence normalizes a reported confidence to 0–1; models asked for a
+// percentage answer up to 100. Anything else is dropped.
+func confidence(n float64) *float64 {
+	if n > 1 && n <= 100 {
+		n /= 100
+	}
+	if n < 0 || n > 1 {
+		return nil
+	}
+	return &n
+}
+
+// decodeReplyObject decodes the reply as a JSON object, directly or from its
+// outermost {...} block, reporting whether the block had to be extracted.
+func decodeReplyObject(raw string) (obj map[string]any, extracted bool) {
+	if json.Unmarshal([]byte(raw), &obj) == nil {
+		return obj, false
+	}
+	if s := strings.Index(raw, "{"); s >= 0 {
+		if e := strings.LastIndex(raw, "}"); e > s {
+			if json.Unmarshal([]byte(raw[s:e+1]), &obj) == nil {
+				return obj, true
+			}
+		}
+	}
+	return nil, false
+}
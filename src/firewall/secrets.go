@@ -0,0 +1,74 @@
+package firewall
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sensitive config values may be references instead of the secret itself:
+// "env:NAME" reads an environment variable and "file:/path" a file, such as
+// a mounted container secret. The config file keeps the reference; the
+// value is resolved whenever the config is loaded or changed.
+const (
+	secretRefEnv  = "env:"
+	secretRefFile = "file:"
+)
+
+// secretFields are the config fields that accept secret references, by JSON
+// name.
+func (c *Config) secretFields() map[string]*string {
+	return map[string]*string{
+		"inspector_api_key": &c.InspectorAPIKey,
+		"override_token":    &c.OverrideToken,
+	}
+}
+
+func isSecretRef(v string) bool {
+	return strings.HasPrefix(v, secretRefEnv) || strings.HasPrefix(v, secretRefFile)
+}
+
+// resolveSecret returns the value a reference points to, or v itself when
+// it isn't a reference. A file's trailing newline is dropped.
+func resolveSecret(v string) (string, error) {
+	if name, ok := strings.CutPrefix(v, secretRefEnv); ok {
+		value, set := os.LookupEnv(name)
+		if !set || value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	}
+	if path, ok := strings.CutPrefix(v, secretRefFile); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return v, nil
+}
+
+// resolveSecrets returns c with every secret reference replaced by its value.
+func (c Config) resolveSecrets() (Config, error) {
+	for name, field := range c.secretFields() {
+		value, err := resolveSecret(*field)
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", name, err)
+		}
+		*field = value
+	}
+	return c, nil
+}
+
+// keepSecretRefs puts the references of the stored config back into cfg
+// where cfg still holds the value they resolved to, so a config taken from
+// GetConfig and saved again keeps its references instead of the secrets.
+func keepSecretRefs(cfg, stored, resolved Config) Config {
+	storedFields, resolvedFields := stored.secretFields(), resolved.secretFields()
+	for name, field := range cfg.secretFields() {
+		if ref := *storedFields[name]; isSecretRef(ref) && *field == *resolvedFields[name] {
+			*field = ref
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,80 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// snippetMaxLine bounds the partial line a snippetWriter holds; a response
+// line longer than this isn't worth parsing for a snippet.
+const snippetMaxLine = 1 << 20
+
+// snippetWriter collects the first max characters of the text generated in
+// an Ollama chat or generate response as it is copied to the client, for
+// LogResponseSnippet.
+type snippetWriter struct {
+	max    int
+	isChat bool
+	line   []byte
+	text   strings.Builder
+	runes  int
+	done   bool
+}
+
+func (s *snippetWriter) Write(p []byte) (int, error) {
+	if s.done {
+		return len(p), nil
+	}
+	s.line = append(s.line, p...)
+	for !s.done {
+		i := bytes.IndexByte(s.line, '\n')
+		if i < 0 {
+			break
+		}
+		s.add(s.line[:i])
+		s.line = s.line[i+1:]
+	}
+	if len(s.line) > snippetMaxLine {
+		s.done = true
+	}
+	return len(p), nil
+}
+
+// add appends the text of one response line.
+func (s *snippetWriter) add(line []byte) {
+	var obj map[string]any
+	if json.Unmarshal(line, &obj) != nil {
+		return
+	}
+	for _, r := range chunkText(obj, s.isChat) {
+		if s.runes == s.max {
+			s.done = true
+			return
+		}
+		s.text.WriteRune(r)
+		s.runes++
+	}
+}
+
+// snippet returns the collected text, including a final line without a
+// newline, as a non-streaming response may end.
+func (s *snippetWriter) snippet() string {
+	if !s.done && len(bytes.TrimSpace(s.line)) > 0 {
+		s.add(s.line)
+		s.line = nil
+	}
+	return s.text.String()
+}
+
+// responseSnippet prepares a snippet for the log like request content:
+// redacted with RedactLogs, and not stored at all under hash-only logging.
+func responseSnippet(cfg Config, text string) string {
+	if cfg.LogContentChars < 0 {
+		return ""
+	}
+	if cfg.RedactLogs {
+		text = redactForLog(text)
+	}
+	return text
+}
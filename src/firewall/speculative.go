@@ -0,0 +1,107 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+)
+
+var errSpeculativeOverflow = errors.New("speculative response exceeds buffer limit")
+
+// captureWriter is an http.ResponseWriter that buffers a backend response in
+// memory instead of sending it, up to a size limit.
+type captureWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	maxBytes int
+	overflow bool
+}
+
+func newCaptureWriter(maxBytes int) *captureWriter {
+	return &captureWriter{header: make(http.Header), status: http.StatusOK, maxBytes: maxBytes}
+}
+
+func (c *captureWriter) Header() http.Header { return c.header }
+
+func (c *captureWriter) WriteHeader(status int) { c.status = status }
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.maxBytes > 0 && c.body.Len()+len(b) > c.maxBytes {
+		c.overflow = true
+		return 0, errSpeculativeOverflow
+	}
+	return c.body.Write(b)
+}
+
+// speculation is a backend call started in parallel with inspection. Its
+// response is held in memory until the verdict is known.
+type speculation struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	buf    *captureWriter
+
+	promptTokens int
+	evalTokens   int
+}
+
+// startSpeculation forwards the request to the backend immediately, buffering
+// the response instead of writing it to the client.
+func (p *Proxy) startSpeculation(r *http.Request, body []byte, maxBytes int) *speculation {
+	ctx, cancel := context.WithCancel(r.Context())
+	spec := &speculation{
+		done:   make(chan struct{}),
+		cancel: cancel,
+		buf:    newCaptureWriter(maxBytes),
+	}
+	go func() {
+		defer close(spec.done)
+		spec.promptTokens, spec.evalTokens = p.forward(spec.buf, r.WithContext(ctx), body, nil)
+	}()
+	return spec
+}
+
+// discard aborts the backend call and drops anything buffered so far.
+// It is safe to call on a nil or already released speculation.
+func (spec *speculation) discard() {
+	if spec == nil {
+		return
+	}
+	spec.cancel()
+	<-spec.done
+}
+
+// release sends the response to the client: the buffered speculative one if
+// there is one, otherwise a regular forward. If the speculative response
+// overflowed its buffer, the request is forwarded again.
+func (p *Proxy) release(w http.ResponseWriter, r *http.Request, body []byte, warning *responseWarning, spec *speculation) (int, int) {
+	if spec == nil {
+		return p.forward(w, r, body, warning)
+	}
+
+	// Inspection is done; the buffered response may take as long as it needs
+	responseStarted(r.Context())
+	<-spec.done
+	if spec.buf.overflow {
+		reqLogf(r.Context(), "speculative response exceeded %d bytes, forwarding again", spec.buf.maxBytes)
+		return p.forward(w, r, body, warning)
+	}
+
+	for key, values := range spec.buf.header {
+		if http.CanonicalHeaderKey(key) == http.CanonicalHeaderKey(requestIDHeader) {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	if warning != nil {
+		resp := &http.Response{StatusCode: spec.buf.status, Header: spec.buf.header}
+		copyWithWarning(w, resp, bytes.NewReader(spec.buf.body.Bytes()), warning)
+	} else {
+		w.WriteHeader(spec.buf.status)
+		w.Write(spec.buf.body.Bytes())
+	}
+	return spec.promptTokens, spec.evalTokens
+}
@@ -0,0 +1,178 @@
+package firewall
+
+import (
+	"sort"
+	"time"
+)
+
+type LatencyStats struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+}
+
+type Stats struct {
+	Window          string                 `json:"window"`
+	Total           int                    `json:"total"`
+	ByAction        map[string]int         `json:"by_action"`
+	ByRiskLevel     map[string]int         `json:"by_risk_level"`
+	InspectorTokens int                    `json:"inspector_tokens"`
+	// Contradicted counts verdicts whose model risk_level disagreed with its
+	// score, out of Judged model verdicts; a high rate suggests the inspector
+	// model is too small
+	Contradicted     int                    `json:"contradicted"`
+	Judged           int                    `json:"judged"`
+	ContradictionPct float64                `json:"contradiction_pct"`
+	InspectTimeMs   LatencyStats           `json:"inspect_time_ms"`
+	QueueWaitMs     LatencyStats           `json:"queue_wait_ms"`
+	TotalTimeMs     LatencyStats           `json:"total_time_ms"`
+	// Latency per action and per risk level, e.g. to check that blocks,
+	// which skip the backend, are faster, or that malicious prompts take
+	// longer to inspect
+	LatencyByAction    map[string]LatencyBreakdown `json:"latency_by_action"`
+	LatencyByRiskLevel map[string]LatencyBreakdown `json:"latency_by_risk_level"`
+	GroupBy         string                 `json:"group_by,omitempty"`
+	Groups          map[string]*GroupStats `json:"groups,omitempty"`
+	Review          ReviewStats            `json:"review"`
+	Breaker         *BreakerState          `json:"breaker,omitempty"`
+}
+
+// LatencyBreakdown is the latency of the logs with one action or risk level.
+type LatencyBreakdown struct {
+	Count         int          `json:"count"`
+	InspectTimeMs LatencyStats `json:"inspect_time_ms"`
+	TotalTimeMs   LatencyStats `json:"total_time_ms"`
+}
+
+// latencySamples collects the durations of a LatencyBreakdown.
+type latencySamples struct {
+	inspect, total []int64
+}
+
+func addLatency(samples map[string]*latencySamples, key string, l InspectionLog) {
+	s := samples[key]
+	if s == nil {
+		s = &latencySamples{}
+		samples[key] = s
+	}
+	s.inspect = append(s.inspect, l.InspectTimeMs)
+	s.total = append(s.total, l.TotalTimeMs)
+}
+
+func latencyBreakdown(samples map[string]*latencySamples) map[string]LatencyBreakdown {
+	out := make(map[string]LatencyBreakdown, len(samples))
+	for key, s := range samples {
+		out[key] = LatencyBreakdown{
+			Count:         len(s.total),
+			InspectTimeMs: latencyStats(s.inspect),
+			TotalTimeMs:   latencyStats(s.total),
+		}
+	}
+	return out
+}
+
+// GroupStats are the counts for one client or backend model.
+type GroupStats struct {
+	Total           int            `json:"total"`
+	ByAction        map[string]int `json:"by_action"`
+	InspectorTokens int            `json:"inspector_tokens"`
+	BackendTokens   int            `json:"backend_tokens"`
+}
+
+// statsGroupings are the values of ?group_by= on /api/stats.
+var statsGroupings = map[string]func(InspectionLog) string{
+	"client": func(l InspectionLog) string { return l.Client },
+	"model":  func(l InspectionLog) string { return l.BackendModel },
+}
+
+// Stats aggregates the stored logs from the last window that match filter. A
+// window of zero or less covers every stored log. With groupBy set to one of
+// statsGroupings, counts are also broken down per client or backend model;
+// logs without one are grouped under "".
+func (s *Store) Stats(window time.Duration, filter LogFilter, groupBy string) Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st := Stats{
+		Window:      "all",
+		ByAction:    map[string]int{},
+		ByRiskLevel: map[string]int{},
+	}
+	groupKey := statsGroupings[groupBy]
+	if groupKey != nil {
+		st.GroupBy = groupBy
+		st.Groups = map[string]*GroupStats{}
+	}
+	var since time.Time
+	if window > 0 {
+		st.Window = window.String()
+		since = time.Now().Add(-window)
+	}
+
+	var inspectMs, queueMs, totalMs []int64
+	byAction := map[string]*latencySamples{}
+	byRisk := map[string]*latencySamples{}
+	for _, l := range s.logs {
+		if l.Timestamp.Before(since) || !filter.match(l) {
+			continue
+		}
+		st.Total++
+		st.ByAction[l.Action]++
+		st.ByRiskLevel[l.RiskLevel]++
+		st.InspectorTokens += l.InspectPromptTokens + l.InspectEvalTokens
+		st.Review.add(l)
+		// Verdicts settled by rules alone never reached the model
+		if l.InspectorModel != "" && l.InspectorModel != "heuristic" && l.Score >= 0 && (l.Fusion == "" || l.ModelScore != nil) {
+			st.Judged++
+			if l.RawRiskLevel != "" {
+				st.Contradicted++
+			}
+		}
+		inspectMs = append(inspectMs, l.InspectTimeMs)
+		queueMs = append(queueMs, l.QueueWaitMs)
+		totalMs = append(totalMs, l.TotalTimeMs)
+		addLatency(byAction, l.Action, l)
+		addLatency(byRisk, l.RiskLevel, l)
+		if groupKey != nil {
+			key := groupKey(l)
+			g := st.Groups[key]
+			if g == nil {
+				g = &GroupStats{ByAction: map[string]int{}}
+				st.Groups[key] = g
+			}
+			g.Total++
+			g.ByAction[l.Action]++
+			g.InspectorTokens += l.InspectPromptTokens + l.InspectEvalTokens
+			g.BackendTokens += l.BackendPromptTokens + l.BackendEvalTokens
+		}
+	}
+	st.Review.finish()
+	if st.Judged > 0 {
+		st.ContradictionPct = float64(st.Contradicted) * 100 / float64(st.Judged)
+	}
+	st.InspectTimeMs = latencyStats(inspectMs)
+	st.QueueWaitMs = latencyStats(queueMs)
+	st.TotalTimeMs = latencyStats(totalMs)
+	st.LatencyByAction = latencyBreakdown(byAction)
+	st.LatencyByRiskLevel = latencyBreakdown(byRisk)
+	return st
+}
+
+func latencyStats(values []int64) LatencyStats {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return LatencyStats{
+		P50: percentile(values, 50),
+		P95: percentile(values, 95),
+	}
+}
+
+// percentile returns the nearest-rank percentile of an ascending slice.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
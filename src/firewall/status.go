@@ -0,0 +1,107 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// statusProbeTimeout bounds each connectivity probe.
+	statusProbeTimeout = 3 * time.Second
+	// statusCacheTTL is how long a probe result is reused, so dashboards
+	// polling /api/status don't hit the backends on every refresh.
+	statusCacheTTL = 10 * time.Second
+)
+
+type EndpointStatus struct {
+	URL       string    `json:"url"`
+	Up        bool      `json:"up"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	// Disabled is set for the inspector in heuristic mode, which never calls it.
+	Disabled  bool      `json:"disabled,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type ConnectivityStatus struct {
+	Inspector EndpointStatus `json:"inspector"`
+	Backend   EndpointStatus `json:"backend"`
+}
+
+// statusCache holds the last connectivity probe.
+type statusCache struct {
+	mu     sync.Mutex
+	status ConnectivityStatus
+	at     time.Time
+}
+
+// get returns the cached status, probing again once it is older than
+// statusCacheTTL or the configured URLs changed.
+func (c *statusCache) get(ctx context.Context, cfg Config) ConnectivityStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.at) < statusCacheTTL && c.status.Inspector.URL == cfg.InspectorURL && c.status.Backend.URL == cfg.BackendURL &&
+		c.status.Inspector.Disabled == (cfg.InspectorMode == "heuristic") {
+		return c.status
+	}
+
+	var wg sync.WaitGroup
+	var st ConnectivityStatus
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if cfg.InspectorMode == "heuristic" {
+			st.Inspector = EndpointStatus{URL: cfg.InspectorURL, Disabled: true, CheckedAt: time.Now()}
+			return
+		}
+		path, apiKey := "/api/tags", ""
+		if cfg.InspectorType == "openai" {
+			path, apiKey = "/v1/models", cfg.InspectorAPIKey
+		}
+		st.Inspector = probe(ctx, cfg.InspectorURL, path, apiKey)
+	}()
+	go func() {
+		defer wg.Done()
+		st.Backend = probe(ctx, cfg.BackendURL, "/api/tags", "")
+	}()
+	wg.Wait()
+
+	c.status, c.at = st, time.Now()
+	return st
+}
+
+// probe sends a GET to baseURL+path and reports whether it answered 200.
+func probe(ctx context.Context, baseURL, path, apiKey string) EndpointStatus {
+	st := EndpointStatus{URL: baseURL, CheckedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(ctx, statusProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	st.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		st.Error = fmt.Sprintf("%s returned %d", path, resp.StatusCode)
+		return st
+	}
+	st.Up = true
+	return st
+}
@@ -0,0 +1,843 @@
+package firewall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	ProxyAddr      string `json:"proxy_addr"`
+	WebAddr        string `json:"web_addr"`
+	BackendURL     string `json:"backend_url"`
+	InspectorURL   string `json:"inspector_url"`
+	InspectorType   string `json:"inspector_type"`
+	InspectorMode   string          `json:"inspector_mode"`
+	HeuristicRules  []HeuristicRule `json:"heuristic_rules,omitempty"`
+	Rules           []Rule          `json:"rules,omitempty"`
+	ScoreFusion     string          `json:"score_fusion"`
+	EscalationModel string          `json:"escalation_model"`
+	EscalationBand  int             `json:"escalation_band"`
+	RuleWeight      int             `json:"rule_weight"`
+	InspectorAPIKey string `json:"inspector_api_key,omitempty"`
+	InspectorModel string `json:"inspector_model"`
+	AutoPullModel  bool   `json:"auto_pull_model"`
+	PullTimeoutSec int    `json:"pull_timeout_sec"`
+	Threshold      int    `json:"threshold"`
+	QuarantineAt         int    `json:"quarantine_at"`
+	QuarantineTimeoutSec int    `json:"quarantine_timeout_sec"`
+	QuarantineDefault    string `json:"quarantine_default"`
+	WarnAt          int    `json:"warn_at"`
+	WarnTemplate    string `json:"warn_template"`
+	WarnPosition    string `json:"warn_position"`
+	ExplanationLanguage string            `json:"explanation_language"`
+	BlockTemplates      map[string]string `json:"block_templates,omitempty"`
+	BlockStatusCode     int               `json:"block_status_code"`
+	SuspiciousAt    int    `json:"suspicious_at"`
+	MaliciousAt     int    `json:"malicious_at"`
+	RiskLevelSource string `json:"risk_level_source"`
+	MaxInspectTokens int   `json:"max_inspect_tokens"`
+	PromptMaxTokens  map[string]int `json:"prompt_max_tokens,omitempty"`
+	InspectorTemperature float64 `json:"inspector_temperature"`
+	InspectorSeed        int     `json:"inspector_seed"`
+	InspectorOptions     map[string]any `json:"inspector_options,omitempty"`
+	InspectorKeepAlive   string  `json:"inspector_keep_alive,omitempty"`
+	StreamInspector      bool    `json:"stream_inspector"`
+	SamplePasses         int     `json:"sample_passes"`
+	SampleAggregation    string  `json:"sample_aggregation"`
+	ParseWarnPercent int   `json:"parse_warn_percent"`
+	NormalizeUnicode bool  `json:"normalize_unicode"`
+	MapHomoglyphs    bool  `json:"map_homoglyphs"`
+	MaxConcurrentInspections int `json:"max_concurrent_inspections"`
+	QueueDepth               int    `json:"queue_depth"`
+	OverloadPolicy           string `json:"overload_policy"`
+	Speculative         bool `json:"speculative"`
+	SpeculativeMaxBytes int  `json:"speculative_max_bytes"`
+	MaxBodyBytes        int64 `json:"max_body_bytes"`
+	RequestTimeoutMs    int   `json:"request_timeout_ms"`
+	TuningConcurrency   int   `json:"tuning_concurrency"`
+	CompareTimeoutSec   int   `json:"compare_timeout_sec"`
+	BatchTimeoutSec     int   `json:"batch_timeout_sec"`
+	CostPer1KTokens     map[string]float64 `json:"cost_per_1k_tokens,omitempty"`
+	TrackTokens         bool  `json:"track_tokens"`
+	InspectLinks   bool  `json:"inspect_links"`
+	FetchLinks     bool  `json:"fetch_links"`
+	FetchTimeoutMs int   `json:"fetch_timeout_ms"`
+	FetchMaxBytes  int64 `json:"fetch_max_bytes"`
+	FetchMaxLinks  int   `json:"fetch_max_links"`
+	MinInspectChars     int   `json:"min_inspect_chars"`
+	MaxInspectChars     int   `json:"max_inspect_chars"`
+	DedupeMessages      bool  `json:"dedupe_messages"`
+	SampleRate          float64 `json:"sample_rate"`
+	SampleByContent     bool    `json:"sample_by_content"`
+	MaxIdleConnsPerHost     int `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutSec      int `json:"idle_conn_timeout_sec"`
+	InspectorTimeoutSec     int `json:"inspector_timeout_sec"`
+	BackendHeaderTimeoutSec int `json:"backend_header_timeout_sec"`
+	BackendReadTimeoutSec   int `json:"backend_read_timeout_sec"`
+	BackendRetries          int `json:"backend_retries"`
+	BackendRetryBackoffMs   int `json:"backend_retry_backoff_ms"`
+	FailMode           string `json:"fail_mode"`
+	BreakerFailures    int    `json:"breaker_failures"`
+	BreakerWindowSec   int    `json:"breaker_window_sec"`
+	BreakerCooldownSec int    `json:"breaker_cooldown_sec"`
+	OverrideToken      string `json:"override_token,omitempty"`
+	DeniedPaths  []string `json:"denied_paths"`
+	PassthroughPolicy string   `json:"passthrough_policy"`
+	PassthroughPaths  []string `json:"passthrough_paths,omitempty"`
+	AllowedPaths []string `json:"allowed_paths"`
+	AllowedRequestHeaders  []string `json:"allowed_request_headers,omitempty"`
+	DeniedRequestHeaders   []string `json:"denied_request_headers,omitempty"`
+	AllowedResponseHeaders []string `json:"allowed_response_headers,omitempty"`
+	DeniedResponseHeaders  []string `json:"denied_response_headers,omitempty"`
+	TrustedProxies         []string `json:"trusted_proxies,omitempty"`
+	TrustedTools   []string `json:"trusted_tools,omitempty"`
+	DefaultBackendModel string `json:"default_backend_model,omitempty"`
+	LogContentChars  int   `json:"log_content_chars"`
+	MinLogScore      int   `json:"min_log_score"`
+	MaxLogs          int   `json:"max_logs"`
+	LogResponseSnippet int `json:"log_response_snippet"`
+	AuditFile        string `json:"audit_file,omitempty"`
+	AuditFsyncSec    int    `json:"audit_fsync_sec"`
+	AuditHashChain   bool   `json:"audit_hash_chain"`
+	RedactLogs       bool  `json:"redact_logs"`
+	DebugInspector   bool  `json:"debug_inspector"`
+	RepromptOnParseFail bool `json:"reprompt_on_parse_fail"`
+	RawResponseChars int   `json:"raw_response_chars"`
+	ActivePrompt   string `json:"active_prompt"`
+	PromptRoutes   map[string]string `json:"prompt_routes,omitempty"`
+	ContentRoutes  []ContentRoute    `json:"content_routes,omitempty"`
+	InspectionChain []string         `json:"inspection_chain,omitempty"`
+	InspectRoles   []string          `json:"inspect_roles"`
+	InspectSystemSeparately bool   `json:"inspect_system_separately"`
+	SystemThreshold         int    `json:"system_threshold"`
+	SystemPrompt            string `json:"system_prompt,omitempty"`
+	InspectorSchema  InspectorSchema `json:"inspector_schema"`
+	ResponseScan     string          `json:"response_scan"`
+	ResponseScanLLM  bool            `json:"response_scan_llm"`
+	ResponseScanMaxBytes int64       `json:"response_scan_max_bytes"`
+	SecretPatterns   []SecretPattern `json:"secret_patterns,omitempty"`
+	ProtectedPrompts []string        `json:"protected_prompts,omitempty"`
+	CustomPrompt   string `json:"custom_prompt"`
+}
+
+type InspectionLog struct {
+	ID            int       `json:"id"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Client        string    `json:"client,omitempty"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	Content       string    `json:"content"`
+	RiskLevel     string    `json:"risk_level"`
+	RawRiskLevel  string    `json:"raw_risk_level,omitempty"`
+	Score         int       `json:"score"`
+	Explanation   string    `json:"explanation"`
+	Action              string `json:"action"`
+	InspectorModel      string `json:"inspector_model"`
+	BackendModel        string `json:"backend_model"`
+	FromTool            bool   `json:"from_tool"`
+	HasTools            bool   `json:"has_tools"`
+	ToolResults         []ToolResult `json:"tool_results,omitempty"`
+	InspectPromptTokens int    `json:"inspect_prompt_tokens"`
+	InspectEvalTokens   int    `json:"inspect_eval_tokens"`
+	BackendPromptTokens int    `json:"backend_prompt_tokens"`
+	BackendEvalTokens   int    `json:"backend_eval_tokens"`
+	RawResponse         string `json:"raw_response,omitempty"`
+	PassScores          []int  `json:"pass_scores,omitempty"`
+	Aggregation         string `json:"aggregation,omitempty"`
+	MatchedRules        []string `json:"matched_rules,omitempty"`
+	ContentRoute        string   `json:"content_route,omitempty"`
+	Categories          []string `json:"categories,omitempty"`
+	Confidence          *float64 `json:"confidence,omitempty"`
+	Spans               []string `json:"spans,omitempty"`
+	ModelScore          *int     `json:"model_score,omitempty"`
+	RuleScore           *int     `json:"rule_score,omitempty"`
+	Fusion              string   `json:"fusion,omitempty"`
+	PrimaryScore        *int     `json:"primary_score,omitempty"`
+	EscalationModel     string   `json:"escalation_model,omitempty"`
+	Chain               []ChainPass `json:"chain,omitempty"`
+	SystemScore         *int     `json:"system_score,omitempty"`
+	SystemRiskLevel     string   `json:"system_risk_level,omitempty"`
+	SystemExplanation   string   `json:"system_explanation,omitempty"`
+	Signature           string `json:"signature,omitempty"`
+	FalsePositive       bool   `json:"false_positive,omitempty"`
+	ReviewLabel         string `json:"review_label,omitempty"`
+	ResponseFindings    []string `json:"response_findings,omitempty"`
+	ResponseSnippet     string   `json:"response_snippet,omitempty"`
+	InspectTimeMs int64     `json:"inspect_time_ms"`
+	QueueWaitMs   int64     `json:"queue_wait_ms"`
+	BackendTimeMs int64     `json:"backend_time_ms"`
+	TotalTimeMs   int64     `json:"total_time_ms"`
+}
+
+const (
+	// defaultMaxLogs is how many log entries are kept in memory by default.
+	defaultMaxLogs = 200
+	// maxLogsLimit caps MaxLogs so a typo can't exhaust memory.
+	maxLogsLimit = 100000
+)
+
+// maxConfigSnapshots is how many previous configs Rollback can step back through.
+const maxConfigSnapshots = 10
+
+var errNoSnapshot = errors.New("no previous config to roll back to")
+
+type Store struct {
+	mu         sync.RWMutex
+	logs       []InspectionLog
+	nextID     int
+	config     Config // as stored, with secret references
+	resolved   Config // config with the references resolved, see GetConfig
+	configPath string
+
+	quarantine       map[int]*QuarantineEntry
+	nextQuarantineID int
+	configHistory    []ConfigAuditEvent
+	snapshots        []Config
+	learned          map[string]*LearnedAllowEntry
+	audit            auditFile
+
+	// Requests counted by action, whether or not their log was kept
+	requestCounts map[string]int64
+	unlogged      int64
+}
+
+// DefaultConfig is the built-in configuration that config.json is layered on.
+func DefaultConfig() Config {
+	return Config{
+		ProxyAddr:      ":11434",
+		WebAddr:        ":8080",
+		BackendURL:     "http://localhost:11434",
+		InspectorURL:   "http://localhost:11434",
+		InspectorType:  "ollama",
+		InspectorMode:  "llm",
+		InspectRoles:   []string{"system", "user", "tool"},
+		ResponseScan:   "off",
+		ResponseScanMaxBytes: 10 << 20,
+		ScoreFusion:    "max",
+		RuleWeight:     50,
+		EscalationBand: 10,
+		InspectorModel: "llama3.2:3b",
+		PullTimeoutSec: 1800,
+		Threshold:      70,
+		SystemThreshold: 50,
+		QuarantineTimeoutSec: 120,
+		QuarantineDefault:    "block",
+		WarnTemplate:   defaultWarnTemplate,
+		WarnPosition:   "prepend",
+		ExplanationLanguage: defaultExplanationLanguage,
+		BlockStatusCode:     http.StatusOK,
+		SuspiciousAt:     30,
+		MaliciousAt:      70,
+		RiskLevelSource:  "score",
+		MaxInspectTokens: 150,
+		InspectorOptions: map[string]any{"num_ctx": defaultInspectorNumCtx},
+		SamplePasses:      1,
+		SampleAggregation: "median",
+		ParseWarnPercent: 20,
+		NormalizeUnicode: true,
+		SpeculativeMaxBytes: 8 << 20,
+		MaxBodyBytes:        10 << 20,
+		TuningConcurrency:   defaultTuningConcurrency,
+		CompareTimeoutSec:   defaultCompareTimeoutSec,
+		BatchTimeoutSec:     defaultBatchTimeoutSec,
+		TrackTokens:         true,
+		FetchTimeoutMs:      3000,
+		FetchMaxBytes:       64 << 10,
+		FetchMaxLinks:       3,
+		SampleRate:          1,
+		MaxIdleConnsPerHost:     16,
+		IdleConnTimeoutSec:      90,
+		InspectorTimeoutSec:     60,
+		BackendHeaderTimeoutSec: 300,
+		BackendReadTimeoutSec:   120,
+		BackendRetries:          2,
+		BackendRetryBackoffMs:   250,
+		FailMode:           "open",
+		OverloadPolicy:     "reject",
+		BreakerFailures:    5,
+		BreakerWindowSec:   60,
+		BreakerCooldownSec: 30,
+		DeniedPaths:        slices.Clone(defaultDeniedPaths),
+		PassthroughPolicy:  "allow",
+		LogContentChars:  100,
+		MaxLogs:          defaultMaxLogs,
+		AuditFsyncSec:    1,
+		RawResponseChars: 2000,
+		ActivePrompt:   "standard",
+	}
+}
+
+func NewStore(configPath string) (*Store, error) {
+	s := &Store{
+		configPath: configPath,
+		nextID:     1,
+		nextQuarantineID: 1,
+		config:     DefaultConfig(),
+		configHistory: loadConfigHistory(configPath),
+		learned:       loadLearnedAllowlist(configPath),
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.config); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.config.Validate(); err != nil {
+		return nil, err
+	}
+	if s.resolved, err = s.config.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewMemoryStore returns a store that keeps cfg and the logs in memory only,
+// for embedding the firewall in another program. Config changes, the learned
+// allowlist and the config history are never written to disk.
+func NewMemoryStore(cfg Config) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	resolved, err := cfg.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		nextID:           1,
+		nextQuarantineID: 1,
+		config:           cfg.clone(),
+		resolved:         resolved.clone(),
+		learned:          map[string]*LearnedAllowEntry{},
+	}, nil
+}
+
+// Reload re-reads the config file and swaps it in if it is valid. Fields
+// missing from the file fall back to the built-in defaults, as at startup.
+// On error the current config stays in place.
+func (s *Store) Reload() error {
+	if s.configPath == "" {
+		return errors.New("store has no config file")
+	}
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return err
+	}
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	resolved, err := cfg.resolveSecrets()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.pushSnapshot(cfg)
+	s.recordConfigChange(s.config, cfg, "reload", "")
+	s.config, s.resolved = cfg, resolved
+	s.mu.Unlock()
+	return nil
+}
+
+// Validate catches config values that would break the proxy at runtime.
+func (c Config) Validate() error {
+	for name, u := range map[string]string{"backend_url": c.BackendURL, "inspector_url": c.InspectorURL} {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("%s: %q is not an absolute URL", name, u)
+		}
+	}
+	for name, v := range map[string]int{"threshold": c.Threshold, "suspicious_at": c.SuspiciousAt, "malicious_at": c.MaliciousAt, "quarantine_at": c.QuarantineAt, "warn_at": c.WarnAt, "rule_weight": c.RuleWeight, "escalation_band": c.EscalationBand, "system_threshold": c.SystemThreshold, "min_log_score": c.MinLogScore} {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s: %d is outside 0-100", name, v)
+		}
+	}
+	if c.InspectorType != "" && c.InspectorType != "ollama" && c.InspectorType != "openai" {
+		return fmt.Errorf("inspector_type: %q must be ollama or openai", c.InspectorType)
+	}
+	if _, err := c.inspectorKeepAlive(); err != nil {
+		return err
+	}
+	if c.InspectorMode != "" && c.InspectorMode != "llm" && c.InspectorMode != "heuristic" {
+		return fmt.Errorf("inspector_mode: %q must be llm or heuristic", c.InspectorMode)
+	}
+	for i, rule := range c.HeuristicRules {
+		if _, err := compileRule(rule.Pattern); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("heuristic_rules[%d] (%s): invalid pattern %q", i, rule.Name, rule.Pattern)
+		}
+	}
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rules[%d] (%s): %w", i, rule.Name, err)
+		}
+	}
+	if c.ScoreFusion != "" && c.ScoreFusion != "max" && c.ScoreFusion != "weighted_average" && c.ScoreFusion != "rules_override" {
+		return fmt.Errorf("score_fusion: %q must be max, weighted_average or rules_override", c.ScoreFusion)
+	}
+	if c.FetchLinks && (c.FetchTimeoutMs <= 0 || c.FetchMaxBytes <= 0) {
+		return fmt.Errorf("fetch_links: fetch_timeout_ms and fetch_max_bytes must be positive")
+	}
+	if c.RiskLevelSource != "" && c.RiskLevelSource != "score" && c.RiskLevelSource != "max_of_both" {
+		return fmt.Errorf("risk_level_source must be score or max_of_both")
+	}
+	if c.AuditFsyncSec < 0 {
+		return fmt.Errorf("audit_fsync_sec must not be negative")
+	}
+	if c.AutoPullModel && c.PullTimeoutSec <= 0 {
+		return fmt.Errorf("pull_timeout_sec must be positive when auto_pull_model is set")
+	}
+	switch c.ResponseScan {
+	case "", "off", "redact", "block":
+	default:
+		return fmt.Errorf("response_scan must be off, redact or block")
+	}
+	if c.ResponseScanMaxBytes < 0 {
+		return fmt.Errorf("response_scan_max_bytes must not be negative")
+	}
+	for i, sp := range c.SecretPatterns {
+		if sp.Name == "" || sp.Pattern == "" {
+			return fmt.Errorf("secret_patterns[%d]: name and pattern are required", i)
+		}
+		if _, err := compileSecretPattern(sp.Pattern); err != nil {
+			return fmt.Errorf("secret_patterns[%d] %q: %w", i, sp.Name, err)
+		}
+	}
+	if len(c.InspectRoles) == 0 {
+		return fmt.Errorf("inspect_roles: at least one role is required")
+	}
+	for _, role := range c.InspectRoles {
+		if role != "system" && role != "user" && role != "assistant" && role != "tool" {
+			return fmt.Errorf("inspect_roles: unknown role %q", role)
+		}
+	}
+	if _, ok := presetPrompts[c.SystemPrompt]; !ok && c.SystemPrompt != "" && c.SystemPrompt != "custom" {
+		return fmt.Errorf("system_prompt: unknown prompt %q", c.SystemPrompt)
+	}
+	if err := c.InspectorSchema.validate(); err != nil {
+		return err
+	}
+	for _, name := range c.InspectionChain {
+		if _, ok := presetPrompts[name]; !ok && name != "custom" {
+			return fmt.Errorf("inspection_chain: unknown prompt %q", name)
+		}
+	}
+	if err := validatePromptRoutes(c.PromptRoutes); err != nil {
+		return fmt.Errorf("prompt_routes: %w", err)
+	}
+	if err := validateContentRoutes(c.ContentRoutes); err != nil {
+		return err
+	}
+	if err := validateBlockTemplates(c.BlockTemplates); err != nil {
+		return fmt.Errorf("block_templates: %w", err)
+	}
+	if err := validatePromptTemplate(c.CustomPrompt); err != nil {
+		return fmt.Errorf("custom_prompt: %w", err)
+	}
+	for name := range c.InspectorOptions {
+		if field, ok := dedicatedInspectorOptions[name]; ok {
+			return fmt.Errorf("inspector_options: set %s with %s instead", name, field)
+		}
+	}
+	for name, n := range c.PromptMaxTokens {
+		if _, ok := presetPrompts[name]; !ok && name != "custom" {
+			return fmt.Errorf("prompt_max_tokens: unknown prompt %q", name)
+		}
+		if n <= 0 {
+			return fmt.Errorf("prompt_max_tokens: %s must be positive", name)
+		}
+	}
+	for field, patterns := range map[string][]string{
+		"allowed_request_headers":  c.AllowedRequestHeaders,
+		"denied_request_headers":   c.DeniedRequestHeaders,
+		"allowed_response_headers": c.AllowedResponseHeaders,
+		"denied_response_headers":  c.DeniedResponseHeaders,
+	} {
+		if err := validateHeaderPatterns(field, patterns); err != nil {
+			return err
+		}
+	}
+	if err := validateTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+	if err := validateTrustedTools(c.TrustedTools); err != nil {
+		return err
+	}
+	if c.PassthroughPolicy != "" && c.PassthroughPolicy != "allow" && c.PassthroughPolicy != "deny" {
+		return fmt.Errorf("passthrough_policy: %q must be allow or deny", c.PassthroughPolicy)
+	}
+	if c.LogResponseSnippet < 0 {
+		return fmt.Errorf("log_response_snippet must not be negative")
+	}
+	if c.RequestTimeoutMs < 0 {
+		return fmt.Errorf("request_timeout_ms must not be negative")
+	}
+	if c.TuningConcurrency < 1 {
+		return fmt.Errorf("tuning_concurrency must be at least 1")
+	}
+	if c.CompareTimeoutSec < 1 || c.BatchTimeoutSec < 1 {
+		return fmt.Errorf("compare_timeout_sec and batch_timeout_sec must be at least 1")
+	}
+	if c.MaxLogs < 1 || c.MaxLogs > maxLogsLimit {
+		return fmt.Errorf("max_logs: %d must be between 1 and %d", c.MaxLogs, maxLogsLimit)
+	}
+	if c.MaxInspectChars < 0 {
+		return fmt.Errorf("max_inspect_chars must not be negative")
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("sample_rate: %g is outside 0-1", c.SampleRate)
+	}
+	if c.MaxConcurrentInspections < 0 || c.QueueDepth < 0 {
+		return fmt.Errorf("max_concurrent_inspections and queue_depth must not be negative")
+	}
+	if c.QueueDepth > 0 && c.MaxConcurrentInspections == 0 {
+		return fmt.Errorf("queue_depth needs max_concurrent_inspections to size the worker pool")
+	}
+	switch c.OverloadPolicy {
+	case "", "forward", "block", "reject":
+	default:
+		return fmt.Errorf("overload_policy: %q must be forward, block or reject", c.OverloadPolicy)
+	}
+	if c.FailMode != "" && c.FailMode != "open" && c.FailMode != "closed" {
+		return fmt.Errorf("fail_mode: %q must be open or closed", c.FailMode)
+	}
+	if c.QuarantineDefault != "" && c.QuarantineDefault != "block" && c.QuarantineDefault != "forward" {
+		return fmt.Errorf("quarantine_default: %q must be block or forward", c.QuarantineDefault)
+	}
+	if c.SampleAggregation != "" && c.SampleAggregation != "mean" && c.SampleAggregation != "median" && c.SampleAggregation != "max" {
+		return fmt.Errorf("sample_aggregation: %q must be mean, median or max", c.SampleAggregation)
+	}
+	if c.BlockStatusCode != http.StatusOK && (c.BlockStatusCode < 400 || c.BlockStatusCode > 499) {
+		return fmt.Errorf("block_status_code: %d must be 200 or a 4xx status", c.BlockStatusCode)
+	}
+	if c.WarnPosition != "" && c.WarnPosition != "prepend" && c.WarnPosition != "append" {
+		return fmt.Errorf("warn_position: %q must be prepend or append", c.WarnPosition)
+	}
+	if _, err := c.resolveSecrets(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetConfig returns the config in effect, with secret references resolved.
+func (s *Store) GetConfig() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resolved.clone()
+}
+
+// StoredConfig returns the config as it is stored, with secret references
+// such as "env:NAME" left as they are.
+func (s *Store) StoredConfig() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.clone()
+}
+
+// clone copies the slice fields too, so callers that decode JSON onto the
+// returned config can't write into the live one.
+func (c Config) clone() Config {
+	c.DeniedPaths = slices.Clone(c.DeniedPaths)
+	c.AllowedPaths = slices.Clone(c.AllowedPaths)
+	c.AllowedRequestHeaders = slices.Clone(c.AllowedRequestHeaders)
+	c.DeniedRequestHeaders = slices.Clone(c.DeniedRequestHeaders)
+	c.AllowedResponseHeaders = slices.Clone(c.AllowedResponseHeaders)
+	c.DeniedResponseHeaders = slices.Clone(c.DeniedResponseHeaders)
+	c.TrustedProxies = slices.Clone(c.TrustedProxies)
+	c.TrustedTools = slices.Clone(c.TrustedTools)
+	c.PassthroughPaths = slices.Clone(c.PassthroughPaths)
+	c.HeuristicRules = slices.Clone(c.HeuristicRules)
+	c.Rules = slices.Clone(c.Rules)
+	c.InspectRoles = slices.Clone(c.InspectRoles)
+	c.SecretPatterns = slices.Clone(c.SecretPatterns)
+	c.ProtectedPrompts = slices.Clone(c.ProtectedPrompts)
+	c.PromptRoutes = maps.Clone(c.PromptRoutes)
+	c.ContentRoutes = slices.Clone(c.ContentRoutes)
+	c.InspectionChain = slices.Clone(c.InspectionChain)
+	c.InspectorSchema = c.InspectorSchema.clone()
+	c.CostPer1KTokens = maps.Clone(c.CostPer1KTokens)
+	c.BlockTemplates = maps.Clone(c.BlockTemplates)
+	c.PromptMaxTokens = maps.Clone(c.PromptMaxTokens)
+	c.InspectorOptions = maps.Clone(c.InspectorOptions)
+	return c
+}
+
+// InspectsRole reports whether chat messages of role are inspected.
+func (c Config) InspectsRole(role string) bool {
+	return slices.Contains(c.InspectRoles, role)
+}
+
+// SetConfig replaces and persists the config. source and actor describe the
+// change for the audit trail (see ConfigHistory). Secrets that came from
+// GetConfig unchanged are stored as their references again.
+func (s *Store) SetConfig(cfg Config, source, actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg = keepSecretRefs(cfg, s.config, s.resolved)
+	resolved, err := cfg.resolveSecrets()
+	if err != nil {
+		return err
+	}
+	s.pushSnapshot(cfg)
+	return s.applyConfig(cfg, resolved, source, actor)
+}
+
+// ResetConfig replaces the config with DefaultConfig and persists it, as a
+// change that Rollback can undo. The listen addresses are kept, since they
+// only take effect on restart; with keepURLs the backend and inspector
+// endpoints, inspector model and API key are kept too.
+func (s *Store) ResetConfig(keepURLs bool, actor string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := DefaultConfig()
+	cfg.ProxyAddr, cfg.WebAddr = s.config.ProxyAddr, s.config.WebAddr
+	if keepURLs {
+		cfg.BackendURL = s.config.BackendURL
+		cfg.InspectorURL = s.config.InspectorURL
+		cfg.InspectorType = s.config.InspectorType
+		cfg.InspectorModel = s.config.InspectorModel
+		cfg.InspectorAPIKey = s.config.InspectorAPIKey
+	}
+	resolved, err := cfg.resolveSecrets()
+	if err != nil {
+		return Config{}, err
+	}
+	s.pushSnapshot(cfg)
+	return cfg.clone(), s.applyConfig(cfg, resolved, "reset", actor)
+}
+
+// pushSnapshot remembers the current config for Rollback if cfg differs
+// from it. The caller holds s.mu.
+func (s *Store) pushSnapshot(cfg Config) {
+	if len(diffConfig(s.config, cfg)) == 0 {
+		return
+	}
+	s.snapshots = append(s.snapshots, s.config.clone())
+	if len(s.snapshots) > maxConfigSnapshots {
+		s.snapshots = s.snapshots[len(s.snapshots)-maxConfigSnapshots:]
+	}
+}
+
+// Rollback restores the config that was active before the last change,
+// after validating it, and persists it. Repeated calls step further back.
+func (s *Store) Rollback(actor string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.snapshots) == 0 {
+		return Config{}, errNoSnapshot
+	}
+	prev := s.snapshots[len(s.snapshots)-1]
+	if err := prev.Validate(); err != nil {
+		return Config{}, fmt.Errorf("previous config is invalid: %w", err)
+	}
+	resolved, err := prev.resolveSecrets()
+	if err != nil {
+		return Config{}, fmt.Errorf("previous config is invalid: %w", err)
+	}
+	s.snapshots = s.snapshots[:len(s.snapshots)-1]
+	return prev.clone(), s.applyConfig(prev, resolved, "rollback", actor)
+}
+
+// CanRollback reports whether there is a previous config to restore.
+func (s *Store) CanRollback() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.snapshots) > 0
+}
+
+// applyConfig records, swaps in and writes cfg, with resolved being cfg with
+// its secret references resolved. The caller holds s.mu.
+func (s *Store) applyConfig(cfg, resolved Config, source, actor string) error {
+	s.recordConfigChange(s.config, cfg, source, actor)
+	s.config, s.resolved = cfg, resolved
+	if s.configPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.configPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(s.configPath, data, 0644)
+}
+
+// writeFileAtomic replaces path with data through a synced temp file in the
+// same directory and a rename, so readers and the next startup see either the
+// old file or the new one, never a partial write.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Store) AddLog(log InspectionLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.ID = s.nextID
+	s.nextID++
+	log.Timestamp = time.Now()
+
+	s.audit.enqueue(s.config, log)
+	if s.requestCounts == nil {
+		s.requestCounts = map[string]int64{}
+	}
+	s.requestCounts[log.Action]++
+	if !s.config.keepsLog(log) {
+		s.unlogged++
+		return
+	}
+	s.logs = append(s.logs, log)
+	// MaxLogs may have shrunk since the last add
+	if s.config.MaxLogs > 0 && len(s.logs) > s.config.MaxLogs {
+		s.logs = s.logs[len(s.logs)-s.config.MaxLogs:]
+	}
+}
+
+// keepsLog reports whether an entry is stored under MinLogScore. Blocks and
+// entries without a verdict (score -1) are always kept.
+func (c Config) keepsLog(l InspectionLog) bool {
+	return l.Score < 0 || l.Score >= c.MinLogScore ||
+		strings.HasPrefix(l.Action, "blocked") || strings.HasPrefix(l.Action, "rejected")
+}
+
+// RequestMetrics counts every logged request by action, including those
+// MinLogScore kept out of the stored logs.
+type RequestMetrics struct {
+	Total    int64            `json:"total"`
+	ByAction map[string]int64 `json:"by_action"`
+	Unlogged int64            `json:"unlogged"`
+}
+
+// RequestMetrics returns the request counters since startup.
+func (s *Store) RequestMetrics() RequestMetrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := RequestMetrics{ByAction: maps.Clone(s.requestCounts), Unlogged: s.unlogged}
+	if m.ByAction == nil {
+		m.ByAction = map[string]int64{}
+	}
+	for _, n := range s.requestCounts {
+		m.Total += n
+	}
+	return m
+}
+
+// LogOrder is the order GetRecentLogs returns logs in.
+type LogOrder int
+
+const (
+	NewestFirst LogOrder = iota
+	OldestFirst
+)
+
+// GetLogs returns all logs, newest first.
+func (s *Store) GetLogs() []InspectionLog {
+	return s.GetRecentLogs(0, NewestFirst, LogFilter{})
+}
+
+// GetRecentLogs returns the latest n logs matching f (all of them when n is
+// 0) in the given order. Only the returned entries are copied.
+func (s *Store) GetRecentLogs(n int, order LogOrder, f LogFilter) []InspectionLog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	size := len(s.logs)
+	if n > 0 && n < size {
+		size = n
+	}
+	result := make([]InspectionLog, 0, size)
+	for i := len(s.logs) - 1; i >= 0 && (n <= 0 || len(result) < n); i-- {
+		if f.match(s.logs[i]) {
+			result = append(result, s.logs[i])
+		}
+	}
+	if order == OldestFirst {
+		slices.Reverse(result)
+	}
+	return result
+}
+
+// CountLogs returns how many stored logs match f.
+func (s *Store) CountLogs(f LogFilter) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !f.Active() {
+		return len(s.logs)
+	}
+	count := 0
+	for _, l := range s.logs {
+		if f.match(l) {
+			count++
+		}
+	}
+	return count
+}
+
+// SearchLogs returns logs whose content or explanation contains query
+// (case-insensitive), newest first.
+func (s *Store) SearchLogs(query string) []InspectionLog {
+	query = strings.ToLower(query)
+	var result []InspectionLog
+	for _, l := range s.GetLogs() {
+		if strings.Contains(strings.ToLower(l.Content), query) || strings.Contains(strings.ToLower(l.Explanation), query) {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+func (s *Store) DeleteLog(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, l := range s.logs {
+		if l.ID == id {
+			s.logs = append(s.logs[:i], s.logs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) ClearLogs() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = nil
+}
@@ -0,0 +1,137 @@
+package firewall
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetConfigWritesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A large field makes a torn write likely to be seen if there were one
+	padding := strings.Repeat("x", 64<<10)
+	const writes = 50
+
+	var stop atomic.Bool
+	var reads atomic.Int64
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				data, err := os.ReadFile(path)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue // before the first write
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				var cfg Config
+				if err := json.Unmarshal(data, &cfg); err != nil {
+					errs <- err
+					return
+				}
+				if cfg.CustomPrompt != padding {
+					errs <- errors.New("read a config with a partial custom_prompt")
+					return
+				}
+				reads.Add(1)
+			}
+		}()
+	}
+
+	cfg := s.GetConfig()
+	cfg.CustomPrompt = padding
+	for i := range writes {
+		cfg.Threshold = i % 100
+		if err := s.SetConfig(cfg, "test", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("reader saw a partial config file: %v", err)
+	}
+	if reads.Load() == 0 {
+		t.Error("readers never read the config file")
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, ".config.json.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+}
+
+func TestAuditFileKeepsChainOrder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AuditFile = filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg.AuditHashChain = true
+	s, err := NewMemoryStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const entries = 200
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range entries / 4 {
+				s.AddLog(InspectionLog{Action: "allowed"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The file is written in the background
+	var lines []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, err := os.ReadFile(cfg.AuditFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines = strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) == entries {
+			break
+		}
+	}
+	if len(lines) != entries {
+		t.Fatalf("audit file has %d lines, want %d", len(lines), entries)
+	}
+
+	n, err := VerifyAuditChain(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil || n != entries {
+		t.Errorf("VerifyAuditChain = %d, %v, want %d, nil", n, err, entries)
+	}
+	for i, line := range lines {
+		var rec InspectionLog
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.ID != i+1 {
+			t.Fatalf("line %d has ID %d, want entries in log order", i+1, rec.ID)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// sourceSystem marks the separate inspection of a chat's system messages.
+const sourceSystem = "system"
+
+// systemBlockedAction is the log action for a request blocked on its system
+// prompt alone.
+const systemBlockedAction = "blocked (system prompt)"
+
+// systemPromptName is the prompt for inspecting system messages on their own:
+// SystemPrompt, or else the one the request would be routed to.
+func (c Config) systemPromptName(meta inspectMeta) string {
+	if c.SystemPrompt != "" {
+		return c.SystemPrompt
+	}
+	return routedPrompt(c, meta)
+}
+
+// systemVerdict is the outcome of inspecting a request's system prompt
+// separately.
+type systemVerdict struct {
+	result *InspectionResult
+	err    error
+}
+
+// inspectSystem starts inspecting system in the background when there is
+// one, so it runs alongside the main inspection. wait returns nil when there
+// was nothing to inspect.
+func (p *Proxy) inspectSystem(ctx context.Context, cfg Config, system string, meta inspectMeta) (wait func() *systemVerdict) {
+	if system == "" {
+		return func() *systemVerdict { return nil }
+	}
+	var v systemVerdict
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		meta.Source = sourceSystem
+		v.result, v.err = p.inspector.InspectWithPrompt(withInspectMeta(ctx, meta), system, cfg.systemPromptName(meta))
+		if v.err != nil {
+			v.err = fmt.Errorf("system prompt: %w", v.err)
+		}
+	}()
+	return func() *systemVerdict {
+		wg.Wait()
+		return &v
+	}
+}
+
+// apply records the system verdict on the log entry and adds its tokens.
+func (v *systemVerdict) apply(l *InspectionLog) {
+	if v == nil || v.result == nil {
+		return
+	}
+	score := v.result.Score
+	l.SystemScore = &score
+	l.SystemRiskLevel = v.result.RiskLevel
+	l.SystemExplanation = v.result.Explanation
+	l.InspectPromptTokens += v.result.PromptTokens
+	l.InspectEvalTokens += v.result.EvalTokens
+}
+
+// blocks reports whether the system prompt reached SystemThreshold.
+func (v *systemVerdict) blocks(cfg Config) bool {
+	return v != nil && v.result != nil && v.result.Score >= cfg.SystemThreshold
+}
+
+// warns reports whether the system prompt reached WarnAt.
+func (v *systemVerdict) warns(cfg Config) bool {
+	return v != nil && v.result != nil && cfg.WarnAt > 0 && v.result.Score >= cfg.WarnAt
+}
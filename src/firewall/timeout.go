@@ -0,0 +1,52 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// errRequestTimeout is the cancellation cause once RequestTimeoutMs runs out.
+var errRequestTimeout = errors.New("request timeout")
+
+type requestTimerKey struct{}
+
+// withRequestTimeout bounds r by RequestTimeoutMs: inspection, queueing,
+// retries and waiting for the backend all count, but the timer stops once
+// the backend response starts (see responseStarted), so a long stream isn't
+// cut off. stop releases the timer.
+func withRequestTimeout(r *http.Request, cfg Config) (_ *http.Request, stop func()) {
+	if cfg.RequestTimeoutMs <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	timer := time.AfterFunc(time.Duration(cfg.RequestTimeoutMs)*time.Millisecond, func() {
+		cancel(errRequestTimeout)
+	})
+	ctx = context.WithValue(ctx, requestTimerKey{}, timer)
+	return r.WithContext(ctx), func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// responseStarted stops the request timeout, if any, once the backend has
+// answered.
+func responseStarted(ctx context.Context) {
+	if timer, ok := ctx.Value(requestTimerKey{}).(*time.Timer); ok {
+		timer.Stop()
+	}
+}
+
+// timedOut reports whether ctx was cancelled by RequestTimeoutMs.
+func timedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRequestTimeout)
+}
+
+// writeTimeout answers a request that ran out of RequestTimeoutMs; code
+// tells whether it was still being inspected or waiting for the backend.
+func writeTimeout(w http.ResponseWriter, cfg Config, code string) {
+	writeJSONError(w, http.StatusGatewayTimeout, code, fmt.Sprintf("request timed out after %dms", cfg.RequestTimeoutMs))
+}
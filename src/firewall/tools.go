@@ -0,0 +1,125 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// toolDefinition is an entry of the "tools" array of an Ollama chat request.
+type toolDefinition struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+// toolsContent renders tool definitions for inspection: each tool's name and
+// description and the descriptions of its parameters, under a header so the
+// inspector can tell them apart from the conversation. Agent frameworks
+// often build these from third-party sources, which makes them a place to
+// hide instructions.
+func toolsContent(tools []toolDefinition) string {
+	if len(tools) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("[Tool definitions]")
+	for _, t := range tools {
+		b.WriteString("\ntool " + t.Function.Name)
+		if t.Function.Description != "" {
+			b.WriteString(": " + t.Function.Description)
+		}
+		var params map[string]any
+		if json.Unmarshal(t.Function.Parameters, &params) == nil {
+			writeParamDescriptions(&b, "", params)
+		}
+	}
+	b.WriteString("\n[End of tool definitions]")
+	return b.String()
+}
+
+// writeParamDescriptions adds the descriptions and enum values of a JSON
+// schema's properties, following nested objects and array items.
+func writeParamDescriptions(b *strings.Builder, prefix string, schema map[string]any) {
+	props, _ := schema["properties"].(map[string]any)
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		prop, ok := props[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		path := prefix + name
+		if desc, _ := prop["description"].(string); desc != "" {
+			b.WriteString("\n  " + path + ": " + desc)
+		}
+		if enum, ok := prop["enum"].([]any); ok {
+			values := make([]string, 0, len(enum))
+			for _, v := range enum {
+				if s, ok := v.(string); ok {
+					values = append(values, s)
+				}
+			}
+			if len(values) > 0 {
+				b.WriteString("\n  " + path + " values: " + strings.Join(values, ", "))
+			}
+		}
+		writeParamDescriptions(b, path+".", prop)
+		if items, ok := prop["items"].(map[string]any); ok {
+			writeParamDescriptions(b, path+"[].", items)
+		}
+	}
+}
+
+// ToolResult names the tool behind a tool message of a chat request and
+// whether it is one of TrustedTools, for the log.
+type ToolResult struct {
+	Name    string `json:"name,omitempty"`
+	Trusted bool   `json:"trusted"`
+}
+
+// trustsTool reports whether name matches TrustedTools, exactly or by prefix
+// for patterns ending in "*". Tool messages without a name are never trusted.
+func (c Config) trustsTool(name string) bool {
+	if name == "" {
+		return false
+	}
+	return slices.ContainsFunc(c.TrustedTools, func(pattern string) bool {
+		return matchPath(pattern, name)
+	})
+}
+
+// onlyTrustedTools reports whether there are tool results and all of them
+// came from trusted tools.
+func onlyTrustedTools(results []ToolResult) bool {
+	return len(results) > 0 && !slices.ContainsFunc(results, func(t ToolResult) bool { return !t.Trusted })
+}
+
+type toolResultsKey struct{}
+
+func withToolResults(r *http.Request, results []ToolResult) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), toolResultsKey{}, results))
+}
+
+func toolResultsFrom(ctx context.Context) []ToolResult {
+	results, _ := ctx.Value(toolResultsKey{}).([]ToolResult)
+	return results
+}
+
+func validateTrustedTools(tools []string) error {
+	for _, t := range tools {
+		if t == "" || t == "*" {
+			return fmt.Errorf("trusted_tools: %q is not a tool name or prefix*", t)
+		}
+	}
+	return nil
+}
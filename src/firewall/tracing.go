@@ -0,0 +1,66 @@
+package firewall
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer hands out spans from the global provider. Until InitTracing installs
+// an exporter that provider is OTel's no-op one, so spans cost next to nothing.
+var tracer = otel.Tracer("github.com/njannasch/ai-context-firewall")
+
+// InitTracing sets up an OTLP/HTTP exporter when OTEL_EXPORTER_OTLP_ENDPOINT
+// (or the traces-specific variant) is set. The exporter reads the rest of the
+// standard OTEL_* variables itself. The returned shutdown func flushes pending
+// spans; it is nil when tracing is off.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("ai-context-firewall")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// startRequestSpan starts the root span for a proxied request, continuing any
+// trace the client sent in its traceparent header.
+func startRequestSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("firewall.request_id", requestIDFrom(r.Context())),
+		))
+	return r.WithContext(ctx), span
+}
+
+// injectTrace writes the current span's trace context into outgoing headers,
+// so the inspector and backend calls show up under the same trace.
+func injectTrace(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
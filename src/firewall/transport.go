@@ -0,0 +1,94 @@
+package firewall
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clientSettings are the tunables of an outgoing HTTP client. Timeout is a
+// total deadline and is only used for the inspector; backend responses can
+// stream for minutes, so the backend relies on header and idle-read timeouts.
+type clientSettings struct {
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	headerTimeout       time.Duration
+	timeout             time.Duration
+}
+
+func backendClientSettings(cfg Config) clientSettings {
+	return clientSettings{
+		maxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		idleConnTimeout:     time.Duration(cfg.IdleConnTimeoutSec) * time.Second,
+		headerTimeout:       time.Duration(cfg.BackendHeaderTimeoutSec) * time.Second,
+	}
+}
+
+func inspectorClientSettings(cfg Config) clientSettings {
+	return clientSettings{
+		maxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		idleConnTimeout:     time.Duration(cfg.IdleConnTimeoutSec) * time.Second,
+		timeout:             time.Duration(cfg.InspectorTimeoutSec) * time.Second,
+	}
+}
+
+// pooledClient keeps one http.Client with a tuned transport, rebuilding it
+// when the settings change so config edits apply without a restart.
+type pooledClient struct {
+	mu       sync.Mutex
+	settings clientSettings
+	client   *http.Client
+}
+
+func (pc *pooledClient) get(s clientSettings) *http.Client {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.client != nil && pc.settings == s {
+		return pc.client
+	}
+	if pc.client != nil {
+		pc.client.CloseIdleConnections()
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   s.maxIdleConnsPerHost,
+		IdleConnTimeout:       s.idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: s.headerTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	pc.settings = s
+	pc.client = &http.Client{Transport: transport, Timeout: s.timeout}
+	return pc.client
+}
+
+// idleTimeoutReader calls onIdle (typically a context cancel) when no data
+// arrives from the underlying reader for the given duration. Unlike a total
+// deadline it lets long generations run as long as tokens keep flowing.
+type idleTimeoutReader struct {
+	r     io.Reader
+	d     time.Duration
+	timer *time.Timer
+}
+
+func newIdleTimeoutReader(r io.Reader, d time.Duration, onIdle func()) *idleTimeoutReader {
+	return &idleTimeoutReader{r: r, d: d, timer: time.AfterFunc(d, onIdle)}
+}
+
+func (t *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.timer.Reset(t.d)
+	}
+	return n, err
+}
+
+func (t *idleTimeoutReader) stop() { t.timer.Stop() }
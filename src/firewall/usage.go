@@ -0,0 +1,104 @@
+package firewall
+
+import (
+	"time"
+)
+
+// TokenUsage sums token counts over a set of calls. Cost is set when
+// CostPer1KTokens has a price for the model.
+type TokenUsage struct {
+	Requests     int      `json:"requests"`
+	PromptTokens int      `json:"prompt_tokens"`
+	EvalTokens   int      `json:"eval_tokens"`
+	TotalTokens  int      `json:"total_tokens"`
+	Cost         *float64 `json:"cost,omitempty"`
+}
+
+func (u *TokenUsage) add(prompt, eval int) {
+	u.Requests++
+	u.PromptTokens += prompt
+	u.EvalTokens += eval
+	u.TotalTokens += prompt + eval
+}
+
+// RoleUsage is the token usage of either the inspector or the backend.
+type RoleUsage struct {
+	TokenUsage
+	ByModel map[string]*TokenUsage `json:"by_model"`
+}
+
+type Usage struct {
+	Window    string    `json:"window"`
+	Inspector RoleUsage `json:"inspector"`
+	Backend   RoleUsage `json:"backend"`
+	// EstimatedCost totals the models that have a price configured.
+	EstimatedCost *float64 `json:"estimated_cost,omitempty"`
+}
+
+// Usage sums inspector and backend tokens per model over the stored logs
+// from the last window, with a cost estimate from costPer1K. A window of zero
+// or less covers every stored log. Calls that reported no tokens (skipped
+// inspections, heuristic mode, blocked requests that never reached the
+// backend) aren't counted.
+func (s *Store) Usage(window time.Duration, costPer1K map[string]float64) Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u := Usage{
+		Window:    "all",
+		Inspector: RoleUsage{ByModel: map[string]*TokenUsage{}},
+		Backend:   RoleUsage{ByModel: map[string]*TokenUsage{}},
+	}
+	var since time.Time
+	if window > 0 {
+		u.Window = window.String()
+		since = time.Now().Add(-window)
+	}
+
+	for _, l := range s.logs {
+		if l.Timestamp.Before(since) {
+			continue
+		}
+		if l.InspectPromptTokens+l.InspectEvalTokens > 0 {
+			u.Inspector.add(l.InspectPromptTokens, l.InspectEvalTokens)
+			modelUsage(u.Inspector.ByModel, l.InspectorModel).add(l.InspectPromptTokens, l.InspectEvalTokens)
+		}
+		if l.BackendPromptTokens+l.BackendEvalTokens > 0 {
+			u.Backend.add(l.BackendPromptTokens, l.BackendEvalTokens)
+			modelUsage(u.Backend.ByModel, l.BackendModel).add(l.BackendPromptTokens, l.BackendEvalTokens)
+		}
+	}
+
+	if len(costPer1K) > 0 {
+		total := u.Inspector.price(costPer1K) + u.Backend.price(costPer1K)
+		u.EstimatedCost = &total
+	}
+	return u
+}
+
+func modelUsage(byModel map[string]*TokenUsage, model string) *TokenUsage {
+	if byModel[model] == nil {
+		byModel[model] = &TokenUsage{}
+	}
+	return byModel[model]
+}
+
+// price sets the cost of every priced model and returns their sum.
+func (r *RoleUsage) price(costPer1K map[string]float64) float64 {
+	var total float64
+	priced := false
+	for model, mu := range r.ByModel {
+		rate, ok := costPer1K[model]
+		if !ok {
+			continue
+		}
+		cost := float64(mu.TotalTokens) / 1000 * rate
+		mu.Cost = &cost
+		total += cost
+		priced = true
+	}
+	if priced {
+		r.Cost = &total
+	}
+	return total
+}
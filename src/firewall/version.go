@@ -0,0 +1,12 @@
+package firewall
+
+// VersionInfo identifies a build, as served by /version.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+func (v VersionInfo) String() string {
+	return v.Version + " (commit " + v.Commit + ", built " + v.BuildDate + ")"
+}
@@ -0,0 +1,140 @@
+package firewall
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultWarnTemplate = "⚠️ This prompt was flagged as {{.RiskLevel}} by AI Context Firewall (score {{.Score}})."
+
+// renderWarning fills the configured warning template with the inspection
+// result. A broken template falls back to the default so a typo never
+// suppresses the warning.
+func renderWarning(tmpl string, result *InspectionResult) string {
+	if tmpl == "" {
+		tmpl = defaultWarnTemplate
+	}
+	t, err := template.New("warning").Parse(tmpl)
+	if err != nil {
+		log.Printf("invalid warn_template, using default: %v", err)
+		t = template.Must(template.New("warning").Parse(defaultWarnTemplate))
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, result); err != nil {
+		log.Printf("warn_template failed, using default: %v", err)
+		buf.Reset()
+		template.Must(template.New("warning").Parse(defaultWarnTemplate)).Execute(&buf, result)
+	}
+	return buf.String()
+}
+
+// responseWarning describes a warning to inject into a forwarded response.
+type responseWarning struct {
+	text   string
+	model  string
+	isChat bool
+	append bool
+}
+
+// withText places the warning before or after the given response text.
+func (rw *responseWarning) withText(text string) string {
+	if rw.append {
+		return text + "\n\n" + rw.text
+	}
+	return rw.text + "\n\n" + text
+}
+
+// chunk builds a standalone streaming chunk carrying only the warning.
+func (rw *responseWarning) chunk() []byte {
+	text := rw.text + "\n\n"
+	if rw.append {
+		text = "\n\n" + rw.text
+	}
+	c := map[string]any{
+		"model":      rw.model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       false,
+	}
+	if rw.isChat {
+		c["message"] = map[string]string{"role": "assistant", "content": text}
+	} else {
+		c["response"] = text
+	}
+	data, _ := json.Marshal(c)
+	return append(data, '\n')
+}
+
+// copyWithWarning relays a backend response to the client with the warning
+// injected. Streaming (NDJSON) responses get an extra chunk before the first
+// chunk or before the final done chunk; single JSON responses are rewritten.
+func copyWithWarning(w http.ResponseWriter, resp *http.Response, src io.Reader, rw *responseWarning) {
+	if resp.StatusCode != http.StatusOK {
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, src)
+		return
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "ndjson") {
+		w.WriteHeader(resp.StatusCode)
+		flusher, _ := w.(http.Flusher)
+		if !rw.append {
+			w.Write(rw.chunk())
+		}
+		br := bufio.NewReader(src)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				if rw.append && isDoneChunk(line) {
+					w.Write(rw.chunk())
+				}
+				w.Write(line)
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(data, &obj); err != nil {
+		// Not something we can rewrite; pass it through untouched
+		w.WriteHeader(resp.StatusCode)
+		w.Write(data)
+		return
+	}
+	if rw.isChat {
+		if msg, ok := obj["message"].(map[string]any); ok {
+			content, _ := msg["content"].(string)
+			msg["content"] = rw.withText(content)
+		}
+	} else {
+		text, _ := obj["response"].(string)
+		obj["response"] = rw.withText(text)
+	}
+	out, _ := json.Marshal(obj)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(out)
+}
+
+func isDoneChunk(line []byte) bool {
+	var chunk struct {
+		Done bool `json:"done"`
+	}
+	return json.Unmarshal(line, &chunk) == nil && chunk.Done
+}
@@ -0,0 +1,894 @@
+package firewall
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+type WebServer struct {
+	// Version is served at /version
+	Version VersionInfo
+
+	store      *Store
+	inspector  *Inspector
+	dashboard  *template.Template
+	config     *template.Template
+	playground *template.Template
+	mux        *http.ServeMux
+	status     statusCache
+	models     modelCache
+}
+
+const (
+	// dashboardStatsWindow is the time window summarized on the dashboard cards.
+	dashboardStatsWindow = 24 * time.Hour
+	// dashboardLogLimit is how many of the latest logs the dashboard lists.
+	dashboardLogLimit = 50
+	// Defaults for the /api/inspect/compare and /api/inspect/batch bounds
+	// (Config.TuningConcurrency, CompareTimeoutSec, BatchTimeoutSec)
+	defaultTuningConcurrency = 4
+	defaultCompareTimeoutSec = 60
+	defaultBatchTimeoutSec   = 600
+)
+
+func NewWebServer(store *Store, inspector *Inspector) (*WebServer, error) {
+	dashboardTmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/dashboard.html")
+	if err != nil {
+		return nil, err
+	}
+	configTmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/config.html")
+	if err != nil {
+		return nil, err
+	}
+	playgroundTmpl, err := template.ParseFS(templateFS, "templates/layout.html", "templates/playground.html")
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &WebServer{
+		store:      store,
+		inspector:  inspector,
+		dashboard:  dashboardTmpl,
+		config:     configTmpl,
+		playground: playgroundTmpl,
+		mux:        http.NewServeMux(),
+	}
+
+	ws.mux.HandleFunc("/", ws.handleDashboard)
+	ws.mux.HandleFunc("/config", ws.handleConfig)
+	ws.mux.HandleFunc("/playground", ws.handlePlayground)
+	ws.mux.HandleFunc("/api/logs", ws.handleAPILogs)
+	ws.mux.HandleFunc("/api/logs/delete", ws.handleAPIDeleteLog)
+	ws.mux.HandleFunc("/api/logs/clear", ws.handleAPIClearLogs)
+	ws.mux.HandleFunc("/api/logs/{id}/mark-false-positive", ws.handleAPIMarkFalsePositive)
+	ws.mux.HandleFunc("/api/logs/{id}/review", ws.handleAPIReview)
+	ws.mux.HandleFunc("/api/allowlist", ws.handleAPIAllowlist)
+	ws.mux.HandleFunc("/api/allowlist/{signature}", ws.handleAPIAllowlistDelete)
+	ws.mux.HandleFunc("/api/config", ws.handleAPIConfig)
+	ws.mux.HandleFunc("/api/config/history", ws.handleAPIConfigHistory)
+	ws.mux.HandleFunc("/api/config/rollback", ws.handleAPIConfigRollback)
+	ws.mux.HandleFunc("/api/config/reset", ws.handleAPIConfigReset)
+	ws.mux.HandleFunc("/api/models", ws.handleAPIModels)
+	ws.mux.HandleFunc("/api/metrics", ws.handleAPIMetrics)
+	ws.mux.HandleFunc("/api/quarantine", ws.handleAPIQuarantine)
+	ws.mux.HandleFunc("/api/quarantine/{id}", ws.handleAPIQuarantineDecision)
+	ws.mux.HandleFunc("/api/stats", ws.handleAPIStats)
+	ws.mux.HandleFunc("/api/usage", ws.handleAPIUsage)
+	ws.mux.HandleFunc("/api/status", ws.handleAPIStatus)
+	ws.mux.HandleFunc("/version", ws.handleVersion)
+	ws.mux.HandleFunc("/readyz", ws.handleReadyz)
+	ws.mux.HandleFunc("/api/inspect/compare", ws.handleAPIInspectCompare)
+	ws.mux.HandleFunc("/api/inspect/batch", ws.handleAPIInspectBatch)
+	ws.mux.HandleFunc("/api/prompts", ws.handleAPIPrompts)
+	ws.mux.HandleFunc("/api/prompts/{name}", ws.handleAPIPrompt)
+
+	return ws, nil
+}
+
+func (ws *WebServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws.mux.ServeHTTP(w, r)
+}
+
+func (ws *WebServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	filter := logFilterFrom(r)
+	data := struct {
+		Title    string
+		Nav      string
+		Config   Config
+		Logs     []InspectionLog
+		Total    int
+		NewestID int
+		Stats    Stats
+		Held     []QuarantineEntry
+		Filter   LogFilter
+		Health   InspectorHealth
+	}{
+		Title:  "Dashboard",
+		Nav:    "dashboard",
+		Config: ws.store.GetConfig(),
+		Logs:   ws.store.GetRecentLogs(dashboardLogLimit, NewestFirst, filter),
+		Total:  ws.store.CountLogs(filter),
+		Stats:  ws.store.Stats(dashboardStatsWindow, filter, ""),
+		Held:   ws.store.ListQuarantine(),
+		Filter: filter,
+		Health: ws.inspector.Health(),
+	}
+	if len(data.Logs) > 0 {
+		data.NewestID = data.Logs[0].ID
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ws.dashboard.ExecuteTemplate(w, "layout.html", data)
+}
+
+func (ws *WebServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	saved := false
+	saveErr := ""
+
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+
+		clamp := func(v, lo, hi int) int {
+			if v < lo {
+				return lo
+			}
+			if v > hi {
+				return hi
+			}
+			return v
+		}
+
+		threshold, _ := strconv.Atoi(r.FormValue("threshold"))
+		threshold = clamp(threshold, 0, 100)
+
+		suspiciousAt, _ := strconv.Atoi(r.FormValue("suspicious_at"))
+		suspiciousAt = clamp(suspiciousAt, 0, 100)
+
+		maliciousAt, _ := strconv.Atoi(r.FormValue("malicious_at"))
+		maliciousAt = clamp(maliciousAt, 0, 100)
+
+		maxInspectTokens, _ := strconv.Atoi(r.FormValue("max_inspect_tokens"))
+		if maxInspectTokens < 50 {
+			maxInspectTokens = 50
+		}
+
+		// Start from the current config so settings not exposed in the form are kept
+		cfg := ws.store.GetConfig()
+		cfg.BackendURL = r.FormValue("backend_url")
+		cfg.InspectorURL = r.FormValue("inspector_url")
+		cfg.InspectorType = r.FormValue("inspector_type")
+		cfg.InspectorModel = r.FormValue("inspector_model")
+		cfg.InspectorMode = r.FormValue("inspector_mode")
+		cfg.Threshold = threshold
+		cfg.SuspiciousAt = suspiciousAt
+		cfg.MaliciousAt = maliciousAt
+		cfg.MaxInspectTokens = maxInspectTokens
+		cfg.InspectRoles = r.Form["inspect_roles"]
+		cfg.ActivePrompt = r.FormValue("active_prompt")
+		cfg.CustomPrompt = r.FormValue("custom_prompt")
+
+		if err := cfg.Validate(); err != nil {
+			saveErr = err.Error()
+		} else if err := ws.store.SetConfig(cfg, "web form", r.RemoteAddr); err != nil {
+			saveErr = err.Error()
+		} else {
+			saved = true
+		}
+	}
+
+	data := struct {
+		Title   string
+		Nav     string
+		Config  Config
+		Saved   bool
+		SaveErr string
+		Presets map[string]string
+		CanRollback bool
+		Learned     []LearnedAllowEntry
+	}{
+		Title:   "Configuration",
+		Nav:     "config",
+		Config:  ws.store.GetConfig(),
+		Saved:   saved,
+		SaveErr: saveErr,
+		Presets: presetPrompts,
+		CanRollback: ws.store.CanRollback(),
+		Learned:     ws.store.LearnedAllowlist(),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ws.config.ExecuteTemplate(w, "layout.html", data)
+}
+
+func (ws *WebServer) handlePlayground(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Title   string
+		Nav     string
+		Config  Config
+		Prompts []string
+	}{
+		Title:   "Playground",
+		Nav:     "playground",
+		Config:  ws.store.GetConfig(),
+		Prompts: ws.inspector.PromptNames(),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ws.playground.ExecuteTemplate(w, "layout.html", data)
+}
+
+// CompareResult is one verdict as reported by /api/inspect/compare and the
+// inspect command.
+type CompareResult struct {
+	*InspectionResult
+	Blocked    bool   `json:"blocked"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleAPIPrompts lists every available prompt with its rendered text.
+// ?backend_model= sets the requested model the templates see.
+func (ws *WebServer) handleAPIPrompts(w http.ResponseWriter, r *http.Request) {
+	backendModel := r.URL.Query().Get("backend_model")
+	prompts := []PromptPreview{}
+	for _, name := range ws.inspector.PromptNames() {
+		if preview, ok := ws.inspector.PreviewPrompt(name, backendModel); ok {
+			prompts = append(prompts, preview)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prompts)
+}
+
+// handleAPIPrompt shows one prompt, like handleAPIPrompts.
+func (ws *WebServer) handleAPIPrompt(w http.ResponseWriter, r *http.Request) {
+	preview, ok := ws.inspector.PreviewPrompt(r.PathValue("name"), r.URL.Query().Get("backend_model"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errNotFound, "unknown prompt "+r.PathValue("name"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// handleAPIInspectCompare runs the same content against every available prompt
+// concurrently so presets can be compared side by side.
+func (ws *WebServer) handleAPIInspectCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON")
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "content is required")
+		return
+	}
+
+	cfg := ws.store.GetConfig()
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.CompareTimeoutSec)*time.Second)
+	defer cancel()
+
+	names := ws.inspector.PromptNames()
+	results := make(map[string]CompareResult, len(names))
+	var mu sync.Mutex
+	set := func(name string, entry CompareResult) {
+		mu.Lock()
+		results[name] = entry
+		mu.Unlock()
+	}
+	fanOut(ctx, len(names), cfg.TuningConcurrency, func(i int) {
+		start := time.Now()
+		res, err := ws.inspector.InspectWithPrompt(ctx, req.Content, names[i])
+		entry := CompareResult{InspectionResult: res, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Blocked = res.Score >= cfg.Threshold
+		}
+		set(names[i], entry)
+	}, func(i int) {
+		set(names[i], CompareResult{Error: notStartedError("compare_timeout_sec", cfg.CompareTimeoutSec)})
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// fanOut calls run for each index below n, at most workers at a time. Once
+// ctx is done, the indexes not yet started go to skipped instead, so callers
+// can still report what finished. The inspector calls themselves also count
+// against MaxConcurrentInspections, shared with proxied traffic.
+func fanOut(ctx context.Context, n, workers int, run, skipped func(i int)) {
+	sem := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			skipped(i)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func notStartedError(field string, sec int) string {
+	return fmt.Sprintf("not inspected: the %ds %s ran out first", sec, field)
+}
+
+type batchItem struct {
+	Content       string `json:"content"`
+	ExpectedBlock bool   `json:"expected_block"`
+}
+
+type batchItemResult struct {
+	Index         int    `json:"index"`
+	ExpectedBlock bool   `json:"expected_block"`
+	Blocked       bool   `json:"blocked"`
+	Correct       bool   `json:"correct"`
+	RiskLevel     string `json:"risk_level,omitempty"`
+	Score         int    `json:"score"`
+	Explanation   string `json:"explanation,omitempty"`
+	DurationMs    int64  `json:"duration_ms"`
+	Error         string `json:"error,omitempty"`
+}
+
+type batchSummary struct {
+	Total          int     `json:"total"`
+	Errors         int     `json:"errors"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	TrueNegatives  int     `json:"true_negatives"`
+	FalseNegatives int     `json:"false_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	Accuracy       float64 `json:"accuracy"`
+}
+
+// summarizeBatch computes precision/recall of the block decision against the
+// expected labels. Items that failed inspection are counted as errors only.
+func summarizeBatch(results []batchItemResult) batchSummary {
+	sum := batchSummary{Total: len(results)}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			sum.Errors++
+		case r.Blocked && r.ExpectedBlock:
+			sum.TruePositives++
+		case r.Blocked && !r.ExpectedBlock:
+			sum.FalsePositives++
+		case !r.Blocked && !r.ExpectedBlock:
+			sum.TrueNegatives++
+		default:
+			sum.FalseNegatives++
+		}
+	}
+	if n := sum.TruePositives + sum.FalsePositives; n > 0 {
+		sum.Precision = float64(sum.TruePositives) / float64(n)
+	}
+	if n := sum.TruePositives + sum.FalseNegatives; n > 0 {
+		sum.Recall = float64(sum.TruePositives) / float64(n)
+	}
+	if n := sum.Total - sum.Errors; n > 0 {
+		sum.Accuracy = float64(sum.TruePositives+sum.TrueNegatives) / float64(n)
+	}
+	return sum
+}
+
+// handleAPIInspectBatch runs a labeled corpus through the inspector with the
+// current config and reports per-item verdicts plus precision/recall.
+// An optional ?prompt= query parameter selects a prompt other than the active one.
+func (ws *WebServer) handleAPIInspectBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var items []batchItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON")
+		return
+	}
+
+	cfg := ws.store.GetConfig()
+	promptName := r.URL.Query().Get("prompt")
+	if promptName == "" {
+		promptName = cfg.ActivePrompt
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.BatchTimeoutSec)*time.Second)
+	defer cancel()
+
+	results := make([]batchItemResult, len(items))
+	for i, item := range items {
+		results[i] = batchItemResult{Index: i, ExpectedBlock: item.ExpectedBlock}
+	}
+	fanOut(ctx, len(items), cfg.TuningConcurrency, func(i int) {
+		res := &results[i]
+		start := time.Now()
+		verdict, err := ws.inspector.InspectWithPrompt(ctx, items[i].Content, promptName)
+		res.DurationMs = time.Since(start).Milliseconds()
+		if err != nil {
+			res.Error = err.Error()
+			return
+		}
+		res.RiskLevel = verdict.RiskLevel
+		res.Score = verdict.Score
+		res.Explanation = verdict.Explanation
+		res.Blocked = verdict.Score >= cfg.Threshold
+		res.Correct = res.Blocked == res.ExpectedBlock
+	}, func(i int) {
+		results[i].Error = notStartedError("batch_timeout_sec", cfg.BatchTimeoutSec)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"prompt":    promptName,
+		"threshold": cfg.Threshold,
+		"summary":   summarizeBatch(results),
+		"results":   results,
+	})
+}
+
+// handleAPILogs returns all logs, or only those matching ?q= in their content
+// or explanation. ?limit=N returns only the latest N, and ?order=oldest lists
+// them oldest first instead of newest first.
+func (ws *WebServer) handleAPILogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+	order := NewestFirst
+	switch query.Get("order") {
+	case "", "newest":
+	case "oldest":
+		order = OldestFirst
+	default:
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "order must be newest or oldest")
+		return
+	}
+
+	var logs []InspectionLog
+	if q := query.Get("q"); q != "" {
+		logs = filterLogs(ws.store.SearchLogs(q), logFilterFrom(r))
+		if limit > 0 && len(logs) > limit {
+			logs = logs[:limit]
+		}
+		if order == OldestFirst {
+			slices.Reverse(logs)
+		}
+	} else {
+		logs = ws.store.GetRecentLogs(limit, order, logFilterFrom(r))
+	}
+	if logs == nil {
+		logs = []InspectionLog{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
+
+func (ws *WebServer) handleAPIDeleteLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid id")
+		return
+	}
+	ws.store.DeleteLog(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ws *WebServer) handleAPIClearLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	ws.store.ClearLogs()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIMarkFalsePositive flags a log entry as a false positive.
+// Optional body: {"learn": true, "note": "..."} to also add its content
+// signature to the learned allowlist.
+func (ws *WebServer) handleAPIMarkFalsePositive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid id")
+		return
+	}
+	var req struct {
+		Learn bool   `json:"learn"`
+		Note  string `json:"note"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON: "+err.Error())
+			return
+		}
+	}
+	entry, err := ws.store.MarkFalsePositive(id, req.Learn, req.Note)
+	switch {
+	case errors.Is(err, errLogNotFound):
+		writeJSONError(w, http.StatusNotFound, errNotFound, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+	if req.Learn {
+		log.Printf("log #%d marked as false positive, signature %s added to learned allowlist by %s", id, entry.Signature[:12], r.RemoteAddr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handleAPIReview labels a log entry for the precision and recall estimate
+// in /api/stats. Body: {"label": "attack"}, "benign", or "" to clear.
+func (ws *WebServer) handleAPIReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid id")
+		return
+	}
+	var req struct {
+		Label string `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	entry, err := ws.store.SetReviewLabel(id, req.Label)
+	switch {
+	case errors.Is(err, errLogNotFound):
+		writeJSONError(w, http.StatusNotFound, errNotFound, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handleAPIAllowlist lists the learned allowlist (GET) or adds an entry
+// (POST {"content": "...", "note": "..."}).
+func (ws *WebServer) handleAPIAllowlist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ws.store.LearnedAllowlist())
+	case http.MethodPost:
+		var req struct {
+			Content string `json:"content"`
+			Note    string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON: "+err.Error())
+			return
+		}
+		if strings.TrimSpace(req.Content) == "" {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "content is required")
+			return
+		}
+		entry := LearnedAllowEntry{
+			Signature: contentSignature(req.Content),
+			Snippet:   truncate(storedContent(ws.store.GetConfig(), req.Content), 120),
+			Note:      req.Note,
+		}
+		if err := ws.store.AddLearned(entry); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errInternal, err.Error())
+			return
+		}
+		log.Printf("signature %s added to learned allowlist by %s", entry.Signature[:12], r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAPIAllowlistDelete removes a learned allowlist entry.
+func (ws *WebServer) handleAPIAllowlistDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	found, err := ws.store.RemoveLearned(r.PathValue("signature"))
+	switch {
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	case !found:
+		writeJSONError(w, http.StatusNotFound, errNotFound, "not found")
+		return
+	}
+	log.Printf("signature %s removed from learned allowlist by %s", r.PathValue("signature"), r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIConfigHistory lists config changes, newest first.
+func (ws *WebServer) handleAPIConfigHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws.store.ConfigHistory())
+}
+
+// handleAPIConfigRollback restores the config from before the last change.
+func (ws *WebServer) handleAPIConfigRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	cfg, err := ws.store.Rollback(r.RemoteAddr)
+	switch {
+	case errors.Is(err, errNoSnapshot):
+		writeJSONError(w, http.StatusConflict, errConflict, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// handleAPIConfigReset restores the built-in defaults. ?keep_urls=true keeps
+// the backend and inspector endpoints.
+func (ws *WebServer) handleAPIConfigReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	keepURLs := false
+	if v := r.URL.Query().Get("keep_urls"); v != "" {
+		var err error
+		if keepURLs, err = strconv.ParseBool(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "keep_urls must be true or false")
+			return
+		}
+	}
+	cfg, err := ws.store.ResetConfig(keepURLs, r.RemoteAddr)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errInternal, "failed to save config")
+		return
+	}
+	log.Printf("config reset to defaults by %s (keep_urls=%t)", r.RemoteAddr, keepURLs)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+func (ws *WebServer) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ws.store.StoredConfig())
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// Fields omitted from the request body keep their current values,
+		// secret references included
+		cfg := ws.store.StoredConfig()
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON")
+			return
+		}
+		if err := cfg.Validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, errInvalidRequest, err.Error())
+			return
+		}
+		if err := ws.store.SetConfig(cfg, "api", r.RemoteAddr); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, errInternal, "failed to save config")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cfg)
+		return
+	}
+
+	writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+}
+
+func (ws *WebServer) handleAPIQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws.store.ListQuarantine())
+}
+
+// handleAPIQuarantineDecision approves or denies a held request.
+// Body: {"action": "approve"} or {"action": "deny"}.
+func (ws *WebServer) handleAPIQuarantineDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid id")
+		return
+	}
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid JSON")
+		return
+	}
+	if req.Action != "approve" && req.Action != "deny" {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, `action must be "approve" or "deny"`)
+		return
+	}
+	if !ws.store.ResolveQuarantine(id, req.Action == "approve") {
+		writeJSONError(w, http.StatusNotFound, errNotFound, "no pending request with that id")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ws *WebServer) handleAPIMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Metrics{
+		Parse: parseMetricsSnapshot(),
+		Queue: ws.inspector.QueueMetrics(),
+		Requests: ws.store.RequestMetrics(),
+	})
+}
+
+// handleAPIStatus reports whether the inspector and backend are reachable.
+func (ws *WebServer) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// Not bound to r.Context(): a client going away mustn't cache a false "down"
+	json.NewEncoder(w).Encode(ws.status.get(context.Background(), ws.store.GetConfig()))
+}
+
+// handleReadyz answers 503 while the last inspector probe failed, since
+// requests then go uninspected.
+func (ws *WebServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	health := ws.inspector.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+func (ws *WebServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws.Version)
+}
+
+// handleAPIStats summarizes logs over ?window= (a Go duration such as "1h";
+// defaults to 24h, "0" covers all stored logs).
+func (ws *WebServer) handleAPIStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := windowParam(w, r)
+	if !ok {
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if _, ok := statsGroupings[groupBy]; groupBy != "" && !ok {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid group_by: use client or model")
+		return
+	}
+
+	stats := ws.store.Stats(window, logFilterFrom(r), groupBy)
+	breaker := ws.inspector.BreakerState()
+	stats.Breaker = &breaker
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleAPIUsage sums inspector and backend tokens per model over ?window=
+// (as for /api/stats), with a cost estimate from CostPer1KTokens.
+func (ws *WebServer) handleAPIUsage(w http.ResponseWriter, r *http.Request) {
+	window, ok := windowParam(w, r)
+	if !ok {
+		return
+	}
+	usage := ws.store.Usage(window, ws.store.GetConfig().CostPer1KTokens)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// windowParam reads ?window=, defaulting to dashboardStatsWindow. On a bad
+// value it writes a 400 and returns false.
+func windowParam(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return dashboardStatsWindow, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errInvalidRequest, "invalid window")
+		return 0, false
+	}
+	return d, true
+}
+
+func (ws *WebServer) handleAPIModels(w http.ResponseWriter, r *http.Request) {
+	// Fetch from the specified URL, or fall back to inspector URL
+	cfg := ws.store.GetConfig()
+	ollamaURL := r.URL.Query().Get("url")
+	if ollamaURL == "" {
+		ollamaURL = cfg.InspectorURL
+	}
+	openAI := r.URL.Query().Get("type") == "openai" || (r.URL.Query().Get("type") == "" && cfg.InspectorType == "openai")
+	// The key is only ever sent to the inspector it belongs to, never to a
+	// URL the caller made up
+	apiKey := ""
+	if openAI && ollamaURL == cfg.InspectorURL {
+		apiKey = cfg.InspectorAPIKey
+	}
+
+	// Only the configured hosts are cached; any other URL is fetched each
+	// time, so callers can't grow the cache without bound
+	key := modelListKey{url: ollamaURL, openAI: openAI}
+	var models []json.RawMessage
+	var stale bool
+	var err error
+	if ollamaURL == cfg.InspectorURL || ollamaURL == cfg.BackendURL {
+		models, stale, err = ws.models.get(key, apiKey, r.URL.Query().Get("refresh") == "1")
+	} else {
+		models, err = fetchModelList(key, apiKey)
+	}
+	resp := map[string]any{"models": models}
+	if models == nil {
+		resp["models"] = []any{}
+	}
+	if stale {
+		resp["stale"] = true
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,52 @@
+package firewall
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestAPIModelsSendsKeyOnlyToInspector(t *testing.T) {
+	var mu sync.Mutex
+	auth := map[string]string{}
+	host := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			auth[name] = r.Header.Get("Authorization")
+			mu.Unlock()
+			w.Write([]byte(`{"data":[{"id":"m"}]}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	inspector, foreign := host("inspector"), host("foreign")
+
+	cfg := DefaultConfig()
+	cfg.InspectorURL = inspector.URL
+	cfg.InspectorType = "openai"
+	cfg.InspectorAPIKey = "sk-test-inspector-key"
+	store, err := NewMemoryStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebServer{store: store}
+
+	for _, target := range []string{inspector.URL, foreign.URL} {
+		rec := httptest.NewRecorder()
+		ws.handleAPIModels(rec, httptest.NewRequest(http.MethodGet, "/api/models?type=openai&url="+url.QueryEscape(target), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/models for %s: status %d", target, rec.Code)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := auth["inspector"], "Bearer sk-test-inspector-key"; got != want {
+		t.Errorf("inspector got Authorization %q, want %q", got, want)
+	}
+	if got := auth["foreign"]; got != "" {
+		t.Errorf("foreign URL got Authorization %q, want none", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const unixAddrPrefix = "unix:"
+
+// unixSocketMode lets the owner and its group connect.
+const unixSocketMode = 0660
+
+// listen opens a TCP listener, or a Unix domain socket for addresses of the
+// form "unix:/path/to.sock". cleanup removes the socket file on shutdown.
+func listen(addr string) (l net.Listener, cleanup func(), err error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		l, err = net.Listen("tcp", addr)
+		return l, func() {}, err
+	}
+	if path == "" {
+		return nil, nil, fmt.Errorf("%q: missing socket path", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, nil, err
+	}
+	l, err = net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		l.Close()
+		return nil, nil, fmt.Errorf("set socket permissions: %w", err)
+	}
+	return l, func() { os.Remove(path) }, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a process that
+// didn't shut down cleanly. A socket something still listens on, or a path
+// that isn't a socket, is left alone and reported.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
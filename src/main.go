@@ -1,71 +1,172 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/njannasch/ai-context-firewall/firewall"
 )
 
 func main() {
-	proxyAddr := flag.String("proxy", ":11434", "Proxy listen address")
-	webAddr := flag.String("web", ":8080", "Web UI listen address")
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(runInspectCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(runVerifyAuditCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplayCommand(os.Args[2:]))
+	}
 
-	defaultConfig := "config.json"
-	if exe, err := os.Executable(); err == nil {
-		defaultConfig = filepath.Join(filepath.Dir(exe), "config.json")
+	proxyAddr := flag.String("proxy", ":11434", "Proxy listen address, or unix:/path/to.sock (overrides PROXY_ADDR and proxy_addr)")
+	webAddr := flag.String("web", ":8080", "Web UI listen address (overrides WEB_ADDR and web_addr)")
+	configPath := flag.String("config", defaultConfigPath(), "Config file path")
+	selfTest := flag.Bool("selftest", false, "Run built-in inspector self-test on startup and log the results")
+	selfTestStrict := flag.Bool("selftest-strict", false, "Run the self-test and exit non-zero if any sample fails")
+	dumpConfig := flag.Bool("dump-config", false, "Print the effective config (file, env and flags applied) as JSON and exit")
+	printDefaults := flag.Bool("print-defaults", false, "Print the built-in default config as JSON and exit")
+	showVersion := flag.Bool("version", false, "Print version, commit and build date and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s inspect [flags] [text]\n       %s replay [flags] [file]\n       %s verify-audit <file>\n\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
 	}
-	configPath := flag.String("config", defaultConfig, "Config file path")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println("ai-context-firewall " + versionInfo().String())
+		return
+	}
+
+	if *printDefaults {
+		printJSON(firewall.DefaultConfig())
+		return
+	}
+
 	// Allow environment variables to override config values
-	store, err := NewStore(*configPath)
+	store, err := firewall.NewStore(*configPath)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
 	cfg := store.GetConfig()
-	changed := false
-	if v := os.Getenv("BACKEND_URL"); v != "" {
-		cfg.BackendURL = v
-		changed = true
+	envChanged := applyEnvOverrides(&cfg)
+
+	// Listen addresses: flag > env > config file > built-in default
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if !setFlags["proxy"] && cfg.ProxyAddr != "" {
+		*proxyAddr = cfg.ProxyAddr
 	}
-	if v := os.Getenv("INSPECTOR_URL"); v != "" {
-		cfg.InspectorURL = v
-		changed = true
+	if !setFlags["web"] && cfg.WebAddr != "" {
+		*webAddr = cfg.WebAddr
 	}
-	if v := os.Getenv("INSPECTOR_MODEL"); v != "" {
-		cfg.InspectorModel = v
-		changed = true
+
+	if *dumpConfig {
+		effective := cfg
+		effective.ProxyAddr = *proxyAddr
+		effective.WebAddr = *webAddr
+		if effective.InspectorAPIKey != "" {
+			effective.InspectorAPIKey = "***"
+		}
+		if effective.OverrideToken != "" {
+			effective.OverrideToken = "***"
+		}
+		printJSON(effective)
+		return
 	}
-	if changed {
-		store.SetConfig(cfg)
+
+	if envChanged {
+		if err := cfg.Validate(); err != nil {
+			log.Fatalf("invalid config from environment: %v", err)
+		}
+		if err := store.SetConfig(cfg, "env", ""); err != nil {
+			log.Fatalf("failed to apply environment overrides: %v", err)
+		}
 	}
 
-	inspector := NewInspector(store)
-	proxy := NewProxy(store, inspector)
-	webServer, err := NewWebServer(store)
+	shutdownTracing, err := firewall.InitTracing(context.Background())
+	if err != nil {
+		log.Fatalf("failed to init tracing: %v", err)
+	}
+	// SIGHUP re-reads the config file; a broken file leaves the running config alone
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := store.Reload(); err != nil {
+				log.Printf("config reload failed, keeping current config: %v", err)
+				continue
+			}
+			log.Printf("config reloaded from %s", *configPath)
+		}
+	}()
+
+	inspector := firewall.NewInspector(store)
+	proxy := firewall.NewProxy(store, inspector)
+	webServer, err := firewall.NewWebServer(store, inspector)
 	if err != nil {
 		log.Fatalf("failed to init web server: %v", err)
 	}
+	webServer.Version = versionInfo()
 
 	cfg = store.GetConfig()
-	fmt.Println("AI Context Firewall")
+	fmt.Println("AI Context Firewall " + versionInfo().String())
 	fmt.Printf("  Proxy:     %s\n", *proxyAddr)
 	fmt.Printf("  Web UI:    %s\n", *webAddr)
 	fmt.Printf("  Backend:   %s\n", cfg.BackendURL)
-	fmt.Printf("  Inspector: %s (model: %s)\n", cfg.InspectorURL, cfg.InspectorModel)
+	if cfg.InspectorMode == "heuristic" {
+		fmt.Printf("  Inspector: heuristic rules only (%d rules)\n", len(firewall.HeuristicRules(cfg)))
+	} else {
+		fmt.Printf("  Inspector: %s (model: %s)\n", cfg.InspectorURL, cfg.InspectorModel)
+	}
 	fmt.Printf("  Threshold: %d\n", cfg.Threshold)
 	fmt.Printf("  Prompt:    %s\n", cfg.ActivePrompt)
+	if shutdownTracing != nil {
+		fmt.Println("  Tracing:   OTLP export enabled")
+	}
 	fmt.Println()
 
+	inspector.CheckModel(cfg)
+
+	if *selfTest || *selfTestStrict {
+		if failed := firewall.RunSelfTest(inspector, cfg); failed > 0 && *selfTestStrict {
+			log.Fatalf("self-test failed (%d samples), exiting due to -selftest-strict", failed)
+		}
+	}
+
+	proxyListener, removeSocket, err := listen(*proxyAddr)
+	if err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+
+	// On Ctrl-C / docker stop, remove the proxy's socket file (if any) and
+	// flush buffered spans
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		removeSocket()
+		if shutdownTracing != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdownTracing(ctx)
+		}
+		os.Exit(0)
+	}()
+
 	errCh := make(chan error, 2)
 
 	go func() {
 		log.Printf("Proxy listening on %s", *proxyAddr)
-		errCh <- http.ListenAndServe(*proxyAddr, proxy)
+		errCh <- http.Serve(proxyListener, proxy)
 	}()
 
 	go func() {
@@ -75,3 +176,9 @@ func main() {
 
 	log.Fatal(<-errCh)
 }
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
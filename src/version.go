@@ -0,0 +1,42 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/njannasch/ai-context-firewall/firewall"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionInfo returns the ldflags values, falling back to the VCS stamp Go
+// embeds for builds made inside a git checkout.
+func versionInfo() firewall.VersionInfo {
+	v := firewall.VersionInfo{Version: version, Commit: commit, BuildDate: buildDate}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = s.Value
+				if len(v.Commit) > 12 {
+					v.Commit = v.Commit[:12]
+				}
+			case s.Key == "vcs.time" && v.BuildDate == "":
+				v.BuildDate = s.Value
+			}
+		}
+	}
+	if v.Commit == "" {
+		v.Commit = "unknown"
+	}
+	if v.BuildDate == "" {
+		v.BuildDate = "unknown"
+	}
+	return v
+}